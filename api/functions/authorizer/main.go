@@ -158,6 +158,9 @@ func getWriterResource(methodArn string) []string {
 		"/POST/problems",
 		"/GET/problems/drafts",
 		"/PUT/problems/*/publish",
+		"/POST/problems/*/rejudge",
+		"/POST/submissions/*/rejudge",
+		"/POST/submissions/rejudge",
 	})...)
 }
 
@@ -0,0 +1,143 @@
+// Package handler implements the WebSocket API pushing the status transitions of the submissions,
+// independent of where it is deployed
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/myuon/provenian/api/lib/notify"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+// API Gateway closes the WebSocket connections after 2 hours, and the connections are
+// deleted by the TTL of the table after this long if the $disconnect route is missed
+const connectionLifetime = 3 * time.Hour
+
+// Connection is a WebSocket connection subscribing to the events of a submission or of a user
+type Connection struct {
+	ID           string `json:"id" dynamo:"id"`
+	SubmissionID string `json:"submission_id" dynamo:"submission_id,omitempty"`
+	UserID       string `json:"user_id" dynamo:"user_id,omitempty"`
+	ExpiresAt    int64  `json:"expires_at" dynamo:"expires_at"`
+}
+
+type ConnectionRepo struct {
+	table storage.Table
+}
+
+func NewConnectionRepo(table storage.Table) ConnectionRepo {
+	return ConnectionRepo{
+		table: table,
+	}
+}
+
+func (repo ConnectionRepo) Put(connection Connection) error {
+	return repo.table.Put(connection)
+}
+
+func (repo ConnectionRepo) Delete(ID string) error {
+	return repo.table.Delete("id", ID)
+}
+
+// ListSubscribers returns the connections subscribing to the submission or to the user of the event
+func (repo ConnectionRepo) ListSubscribers(event notify.Event) ([]Connection, error) {
+	var bySubmission []Connection
+	if err := repo.table.Query("submission_id", "submission_id", event.SubmissionID, &bySubmission); err != nil {
+		return nil, err
+	}
+
+	var byUser []Connection
+	if event.UserID != "" {
+		if err := repo.table.Query("user_id", "user_id", event.UserID, &byUser); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(bySubmission, byUser...), nil
+}
+
+func response(statusCode int, body string) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       body,
+	}
+}
+
+// authorizedUser is the `sub` of the authorizer context, or "" without an authorizer
+func authorizedUser(request events.APIGatewayWebsocketProxyRequest) string {
+	context, _ := request.RequestContext.Authorizer.(map[string]interface{})
+	sub, _ := context["sub"].(string)
+	return sub
+}
+
+// Handle handles the $connect and $disconnect routes. The clients connect with the query
+// submission_id or user_id, to receive the events of the submission or of all the submissions
+// of the user, as the JSON of notify.Event.
+// The submissions are public, so anyone may subscribe to one of them, but the stream of a user
+// is only for the user: the authorizer context must have the same user ID as `sub`.
+func Handle(repo ConnectionRepo, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	switch request.RequestContext.RouteKey {
+	case "$connect":
+		connection := Connection{
+			ID:           request.RequestContext.ConnectionID,
+			SubmissionID: request.QueryStringParameters["submission_id"],
+			UserID:       request.QueryStringParameters["user_id"],
+			ExpiresAt:    time.Now().Add(connectionLifetime).Unix(),
+		}
+		if (connection.SubmissionID == "") == (connection.UserID == "") {
+			return response(400, "Give either submission_id or user_id"), nil
+		}
+		if connection.UserID != "" && connection.UserID != authorizedUser(request) {
+			return response(403, "Only the user can subscribe to the submissions of the user"), nil
+		}
+
+		if err := repo.Put(connection); err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		return response(200, ""), nil
+	case "$disconnect":
+		if err := repo.Delete(request.RequestContext.ConnectionID); err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		return response(200, ""), nil
+	}
+
+	return response(400, "Unknown route: "+request.RequestContext.RouteKey), nil
+}
+
+// Deliver sends the events the judge published to the SNS topic to their subscribers.
+// The connections already closed are deleted, and failing to send to one connection
+// does not stop the others.
+func Deliver(repo ConnectionRepo, sender notify.Sender, notification events.SNSEvent) error {
+	for _, record := range notification.Records {
+		var event notify.Event
+		if err := json.Unmarshal([]byte(record.SNS.Message), &event); err != nil {
+			log.Printf("invalid event %v: %v", record.SNS.MessageID, err)
+			continue
+		}
+
+		connections, err := repo.ListSubscribers(event)
+		if err != nil {
+			return err
+		}
+
+		message := []byte(record.SNS.Message)
+		for _, connection := range connections {
+			err := sender.Send(connection.ID, message)
+			if err == notify.ErrGone {
+				err = repo.Delete(connection.ID)
+			}
+			if err != nil {
+				log.Printf("failed to send %v to %v: %v", event.SubmissionID, connection.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
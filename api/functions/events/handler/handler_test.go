@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/myuon/provenian/api/lib/notify"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+func newTestRepo(t *testing.T) (ConnectionRepo, func()) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := storage.NewFileTable(path.Join(dir, "connection.json"), "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewConnectionRepo(table), func() { os.RemoveAll(dir) }
+}
+
+func connectRequest(connectionID string, query map[string]string, authorizer interface{}) events.APIGatewayWebsocketProxyRequest {
+	var request events.APIGatewayWebsocketProxyRequest
+	request.RequestContext.RouteKey = "$connect"
+	request.RequestContext.ConnectionID = connectionID
+	request.RequestContext.Authorizer = authorizer
+	request.QueryStringParameters = query
+
+	return request
+}
+
+func TestHandle(t *testing.T) {
+	repo, cleanup := newTestRepo(t)
+	defer cleanup()
+
+	user := map[string]interface{}{"sub": "alice"}
+	cases := []struct {
+		name       string
+		request    events.APIGatewayWebsocketProxyRequest
+		statusCode int
+	}{
+		{"submission", connectRequest("c1", map[string]string{"submission_id": "s1"}, nil), 200},
+		{"own submissions", connectRequest("c2", map[string]string{"user_id": "alice"}, user), 200},
+		{"submissions of another user", connectRequest("c3", map[string]string{"user_id": "bob"}, user), 403},
+		{"submissions of a user without the authorizer", connectRequest("c4", map[string]string{"user_id": "alice"}, nil), 403},
+		{"both", connectRequest("c5", map[string]string{"submission_id": "s1", "user_id": "alice"}, user), 400},
+		{"neither", connectRequest("c6", nil, user), 400},
+	}
+
+	for _, c := range cases {
+		response, err := Handle(repo, c.request)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if response.StatusCode != c.statusCode {
+			t.Errorf("%s: got %d, want %d", c.name, response.StatusCode, c.statusCode)
+		}
+	}
+
+	connections, err := repo.ListSubscribers(notify.Event{SubmissionID: "s1", UserID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, connection := range connections {
+		ids = append(ids, connection.ID)
+	}
+	sort.Strings(ids)
+	if want := []string{"c1", "c2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got the connections %v, want %v", ids, want)
+	}
+}
+
+// fakeSender fails to send to the connections in errs
+type fakeSender struct {
+	sent map[string][]string
+	errs map[string]error
+}
+
+func (sender fakeSender) Send(connectionID string, message []byte) error {
+	if err := sender.errs[connectionID]; err != nil {
+		return err
+	}
+
+	sender.sent[connectionID] = append(sender.sent[connectionID], string(message))
+	return nil
+}
+
+func TestDeliver(t *testing.T) {
+	repo, cleanup := newTestRepo(t)
+	defer cleanup()
+
+	for _, connection := range []Connection{
+		{ID: "gone", SubmissionID: "s1"},
+		{ID: "failing", SubmissionID: "s1"},
+		{ID: "submission", SubmissionID: "s1"},
+		{ID: "user", UserID: "alice"},
+		{ID: "other", SubmissionID: "s2"},
+	} {
+		if err := repo.Put(connection); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	message, err := json.Marshal(notify.Event{SubmissionID: "s1", UserID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notification events.SNSEvent
+	for _, body := range []string{"invalid", string(message)} {
+		var record events.SNSEventRecord
+		record.SNS.Message = body
+		notification.Records = append(notification.Records, record)
+	}
+
+	sender := fakeSender{
+		sent: map[string][]string{},
+		errs: map[string]error{"gone": notify.ErrGone, "failing": errors.New("failed")},
+	}
+	if err := Deliver(repo, sender, notification); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{"submission": {string(message)}, "user": {string(message)}}
+	if !reflect.DeepEqual(sender.sent, want) {
+		t.Errorf("got %v, want %v", sender.sent, want)
+	}
+
+	connections, err := repo.ListSubscribers(notify.Event{SubmissionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, connection := range connections {
+		if connection.ID == "gone" {
+			t.Errorf("the closed connection is not deleted")
+		}
+	}
+}
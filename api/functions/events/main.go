@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+
+	eventshandler "github.com/myuon/provenian/api/functions/events/handler"
+	"github.com/myuon/provenian/api/lib/notify"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+var connectionTableName = os.Getenv("connectionTableName")
+
+// The endpoint of the management API of the WebSocket API, like https://{api-id}.execute-api.{region}.amazonaws.com/{stage}
+var socketEndpoint = os.Getenv("socketEndpoint")
+
+// handler is invoked by the routes of the WebSocket API, and by the SNS topic the judge publishes to
+func handler(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	sess := session.Must(session.NewSession())
+
+	connectionRepo := eventshandler.NewConnectionRepo(
+		storage.NewDynamoTable(dynamo.NewFromIface(dynamodb.New(sess)).Table(connectionTableName)),
+	)
+
+	var notification events.SNSEvent
+	if err := json.Unmarshal(payload, &notification); err == nil && len(notification.Records) > 0 {
+		sender := notify.NewAPIGatewaySender(apigatewaymanagementapi.New(sess, aws.NewConfig().WithEndpoint(socketEndpoint)))
+		return nil, eventshandler.Deliver(connectionRepo, sender, notification)
+	}
+
+	var request events.APIGatewayWebsocketProxyRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+
+	return eventshandler.Handle(connectionRepo, request)
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,546 @@
+// Package handler implements the problem API, independent of where it is deployed
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+type ProblemRepo struct {
+	blobs        storage.BlobStore
+	problemTable storage.Table
+	draftTable   storage.Table
+}
+
+func NewProblemRepo(blobs storage.BlobStore, problemTable storage.Table, draftTable storage.Table) ProblemRepo {
+	return ProblemRepo{
+		blobs:        blobs,
+		problemTable: problemTable,
+		draftTable:   draftTable,
+	}
+}
+
+func filepath(problemID string, draft bool) string {
+	if draft {
+		return problemID + ".draft.json"
+	}
+
+	return problemID + ".json"
+}
+
+func filepathAttachment(problemID string, language string, filename string, draft bool) string {
+	if draft {
+		return "draft/" + problemID + "/" + language + "/" + filename
+	}
+
+	return problemID + "/" + language + "/" + filename
+}
+
+func (repo ProblemRepo) doGet(problemID string, draft bool) (model.Problem, error) {
+	body, err := repo.blobs.Get(filepath(problemID, draft))
+	if err != nil {
+		return model.Problem{}, err
+	}
+	defer body.Close()
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(body)
+
+	var problem model.Problem
+	if err := json.Unmarshal(buf.Bytes(), &problem); err != nil {
+		return model.Problem{}, err
+	}
+
+	return problem, nil
+}
+
+func (repo ProblemRepo) doPut(problemID string, problem model.Problem, draft bool) error {
+	json, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+
+	if draft {
+		if err := repo.draftTable.Put(problem); err != nil {
+			return err
+		}
+	} else {
+		if err := repo.problemTable.Put(problem); err != nil {
+			return err
+		}
+	}
+
+	if err := repo.blobs.Put(filepath(problemID, draft), strings.NewReader(string(json)), "public, max-age=86400"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo ProblemRepo) saveAttachment(problemID string, language string, filename string, code string, draft bool) error {
+	if err := repo.blobs.Put(filepathAttachment(problemID, language, filename, draft), strings.NewReader(code), "public, max-age=86400"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// read draft attachment file and copy to public attachment
+func (repo ProblemRepo) publishAttachment(problemID string, language string, filename string) error {
+	body, err := repo.blobs.Get(filepathAttachment(problemID, language, filename, true))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(body)
+
+	if err := repo.saveAttachment(problemID, language, filename, buf.String(), false); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// renameAttachment moves the draft attachment file to the new filename
+func (repo ProblemRepo) renameAttachment(problemID string, language string, filename string, newFilename string) error {
+	body, err := repo.blobs.Get(filepathAttachment(problemID, language, filename, true))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(body)
+
+	if err := repo.saveAttachment(problemID, language, newFilename, buf.String(), true); err != nil {
+		return err
+	}
+
+	return repo.blobs.Delete(filepathAttachment(problemID, language, filename, true))
+}
+
+// delete the public attachment files which are not in the files anymore
+func (repo ProblemRepo) unpublishAttachments(problemID string, files model.LanguageFiles) error {
+	for _, language := range model.BaseLanguages {
+		kept := map[string]bool{}
+		for _, filename := range files.Get(language) {
+			kept[filename] = true
+		}
+
+		prefix := filepathAttachment(problemID, language, "", false)
+		keys, err := repo.blobs.List(prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if !kept[strings.TrimPrefix(key, prefix)] {
+				if err := repo.blobs.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (repo ProblemRepo) publishIndex() error {
+	var problems []model.Problem
+	if err := repo.problemTable.Scan(&problems); err != nil {
+		return errors.Wrap(err, "failed to scan")
+	}
+
+	body, err := json.Marshal(problems)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal")
+	}
+
+	if err := repo.blobs.Put("index.json", strings.NewReader(string(body)), "public, max-age=300"); err != nil {
+		return errors.Wrap(err, "failed to put object")
+	}
+
+	return nil
+}
+
+// invalidInput is the error of the input which cannot make a problem
+type invalidInput struct {
+	error
+}
+
+type Attachment struct {
+	Code     string `json:"code"`
+	Filename string `json:"filename"`
+	Language string `json:"language"`
+}
+
+type CreateProblemInput struct {
+	Title       string                  `json:"title"`
+	ContentType string                  `json:"content_type"`
+	Content     string                  `json:"content"`
+	Attachments []Attachment            `json:"attachments"`
+	Goals       model.LanguageGoals     `json:"goals"`
+	Limits      model.Limits            `json:"limits"`
+	Isabelle    model.IsabelleSession   `json:"isabelle"`
+	Axioms      model.LanguageAxioms    `json:"axioms"`
+	Policy      model.Policy            `json:"policy"`
+	Versions    model.LanguageVersions  `json:"versions"`
+	Templates   model.LanguageTemplates `json:"template"`
+}
+
+// This is always "draft" mode
+func (repo ProblemRepo) doCreate(userID string, input CreateProblemInput) error {
+	problemID := uuid.NewV4().String()
+
+	files := model.LanguageFiles{}
+	for index, attachment := range input.Attachments {
+		// The attachments are shared by the versions of the language
+		attachment.Language = model.BaseLanguage(attachment.Language)
+		input.Attachments[index] = attachment
+
+		if !validAttachmentFilename(attachment.Filename) {
+			return invalidInput{errors.New("Invalid filename: " + attachment.Filename)}
+		}
+
+		if attachment.Language == "isabelle" {
+			files.Isabelle = append(files.Isabelle, attachment.Filename)
+		} else if attachment.Language == "coq" {
+			files.Coq = append(files.Coq, attachment.Filename)
+		} else if attachment.Language == "lean4" {
+			files.Lean4 = append(files.Lean4, attachment.Filename)
+		} else {
+			return invalidInput{errors.New("Unsupported language: " + attachment.Language)}
+		}
+	}
+
+	problem := model.NewProblem(problemID, input.Title, input.ContentType, input.Content, userID, files, input.Goals, input.Limits)
+	problem.Isabelle = input.Isabelle
+	problem.Axioms = input.Axioms
+	problem.Policy = input.Policy
+	problem.Versions = input.Versions
+	problem.Templates = input.Templates
+	problem.Languages = problem.SupportedLanguages()
+
+	if err := problem.Versions.Validate(); err != nil {
+		return invalidInput{err}
+	}
+	if err := problem.ValidateTemplates(); err != nil {
+		return invalidInput{err}
+	}
+
+	// In case LanguageFiles contains unsupported language file,
+	// separate the for-loop so that we don't mind to undo the putObject actions
+	for _, attachment := range input.Attachments {
+		if err := repo.saveAttachment(problemID, attachment.Language, attachment.Filename, attachment.Code, true); err != nil {
+			return err
+		}
+	}
+
+	if err := repo.doPut(problemID, problem, true); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The operations on the attachment files of a problem
+const (
+	// Add the file, or replace it if it exists
+	AttachmentPut = "put"
+	// Rename the file to NewFilename
+	AttachmentRename = "rename"
+	AttachmentDelete = "delete"
+)
+
+type AttachmentOperation struct {
+	Op          string `json:"op"`
+	Language    string `json:"language"`
+	Filename    string `json:"filename"`
+	NewFilename string `json:"new_filename"`
+	Code        string `json:"code"`
+}
+
+func validAttachmentFilename(filename string) bool {
+	return filename != "" && filename != "." && filename != ".." && !strings.ContainsAny(filename, "/\\")
+}
+
+func indexOf(filenames []string, filename string) int {
+	for index, name := range filenames {
+		if name == filename {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// applyAttachmentOperations returns the files after the operations, without writing the attachments
+func applyAttachmentOperations(files model.LanguageFiles, operations []AttachmentOperation) (model.LanguageFiles, error) {
+	for _, operation := range operations {
+		filenames := append([]string{}, files.Get(operation.Language)...)
+		if !validAttachmentFilename(operation.Filename) {
+			return model.LanguageFiles{}, errors.New("Invalid filename: " + operation.Filename)
+		}
+		index := indexOf(filenames, operation.Filename)
+
+		switch operation.Op {
+		case AttachmentPut:
+			if index < 0 {
+				filenames = append(filenames, operation.Filename)
+			}
+		case AttachmentRename:
+			if index < 0 {
+				return model.LanguageFiles{}, errors.New("No such attachment: " + operation.Filename)
+			}
+			if !validAttachmentFilename(operation.NewFilename) || indexOf(filenames, operation.NewFilename) >= 0 {
+				return model.LanguageFiles{}, errors.New("Cannot rename to " + operation.NewFilename)
+			}
+			filenames[index] = operation.NewFilename
+		case AttachmentDelete:
+			if index < 0 {
+				return model.LanguageFiles{}, errors.New("No such attachment: " + operation.Filename)
+			}
+			filenames = append(filenames[:index], filenames[index+1:]...)
+		default:
+			return model.LanguageFiles{}, errors.New("Unsupported operation: " + operation.Op)
+		}
+
+		if !files.Set(operation.Language, filenames) {
+			return model.LanguageFiles{}, errors.New("Unsupported language: " + operation.Language)
+		}
+	}
+
+	return files, nil
+}
+
+// UpdateProblemInput updates the draft.
+// The statement and the settings left out (or null) are kept as they are.
+type UpdateProblemInput struct {
+	Title       *string                  `json:"title"`
+	ContentType *string                  `json:"content_type"`
+	Content     *string                  `json:"content"`
+	Goals       *model.LanguageGoals     `json:"goals"`
+	Limits      *model.Limits            `json:"limits"`
+	Isabelle    *model.IsabelleSession   `json:"isabelle"`
+	Axioms      *model.LanguageAxioms    `json:"axioms"`
+	Policy      *model.Policy            `json:"policy"`
+	Versions    *model.LanguageVersions  `json:"versions"`
+	Templates   *model.LanguageTemplates `json:"template"`
+	// Applied in order to the attachments of the draft
+	Attachments []AttachmentOperation `json:"attachments"`
+}
+
+func (repo ProblemRepo) doUpdate(problemID string, userID string, input UpdateProblemInput) error {
+	prev, err := repo.doGet(problemID, true)
+	if err != nil {
+		return err
+	}
+
+	if prev.Writer != userID {
+		return errors.New("unauthorized")
+	}
+
+	files, err := applyAttachmentOperations(prev.Files, input.Attachments)
+	if err != nil {
+		return invalidInput{err}
+	}
+
+	if input.Title != nil {
+		prev.Title = *input.Title
+	}
+	if input.ContentType != nil {
+		prev.ContentType = *input.ContentType
+	}
+	if input.Content != nil {
+		prev.Content = *input.Content
+	}
+	prev.Files = files
+	if input.Goals != nil {
+		prev.Goals = *input.Goals
+	}
+	if input.Limits != nil {
+		prev.Limits = *input.Limits
+	}
+	if input.Isabelle != nil {
+		prev.Isabelle = *input.Isabelle
+	}
+	if input.Axioms != nil {
+		prev.Axioms = *input.Axioms
+	}
+	if input.Policy != nil {
+		prev.Policy = *input.Policy
+	}
+	if input.Versions != nil {
+		prev.Versions = *input.Versions
+	}
+	if input.Templates != nil {
+		prev.Templates = *input.Templates
+	}
+	prev.Languages = prev.SupportedLanguages()
+	prev.UpdatedAt = time.Now().Unix()
+
+	if err := prev.Versions.Validate(); err != nil {
+		return invalidInput{err}
+	}
+	if err := prev.ValidateTemplates(); err != nil {
+		return invalidInput{err}
+	}
+
+	// The files are checked above, so that the attachments are not left half updated
+	for _, operation := range input.Attachments {
+		language := model.BaseLanguage(operation.Language)
+
+		var err error
+		switch operation.Op {
+		case AttachmentPut:
+			err = repo.saveAttachment(problemID, language, operation.Filename, operation.Code, true)
+		case AttachmentRename:
+			err = repo.renameAttachment(problemID, language, operation.Filename, operation.NewFilename)
+		case AttachmentDelete:
+			err = repo.blobs.Delete(filepathAttachment(problemID, language, operation.Filename, true))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return repo.doPut(problemID, prev, true)
+}
+
+func (repo ProblemRepo) doListWriterProblems(userID string, draft bool) ([]model.Problem, error) {
+	var problems []model.Problem
+	if err := repo.draftTable.Query("writer", "writer", userID, &problems); err != nil {
+		return nil, err
+	}
+
+	return problems, nil
+}
+
+func (repo ProblemRepo) doPublish(problemID string, userID string) error {
+	problem, err := repo.doGet(problemID, true)
+	if err != nil {
+		return errors.Wrap(err, "failed to get")
+	}
+
+	if err := repo.doPut(problemID, problem, false); err != nil {
+		return errors.Wrap(err, "failed to put")
+	}
+
+	files := problem.Files
+	for _, filename := range files.Isabelle {
+		repo.publishAttachment(problemID, "isabelle", filename)
+	}
+	for _, filename := range files.Coq {
+		repo.publishAttachment(problemID, "coq", filename)
+	}
+	for _, filename := range files.Lean4 {
+		repo.publishAttachment(problemID, "lean4", filename)
+	}
+	if err := repo.unpublishAttachments(problemID, files); err != nil {
+		return errors.Wrap(err, "failed to delete attachments")
+	}
+
+	return repo.publishIndex()
+}
+
+// Handle handles the request proxied by API Gateway.
+// The authorizer context must have the user ID as `sub`.
+func Handle(problemRepo ProblemRepo, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if event.Resource == "/problems/{problemId}/edit" && event.HTTPMethod == "PUT" {
+		var input UpdateProblemInput
+		if err := json.Unmarshal([]byte(event.Body), &input); err != nil {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+			}, nil
+		}
+
+		err := problemRepo.doUpdate(event.PathParameters["problemId"], event.RequestContext.Authorizer["sub"].(string), input)
+		if _, ok := err.(invalidInput); ok {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers: map[string]string{
+					"Access-Control-Allow-Origin": "*",
+				},
+				Body: err.Error(),
+			}, nil
+		} else if err != nil {
+			panic(err)
+		}
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: 204,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+		}, nil
+	} else if event.Resource == "/problems/{problemId}/publish" && event.HTTPMethod == "PUT" {
+		if err := problemRepo.doPublish(event.PathParameters["problemId"], event.RequestContext.Authorizer["sub"].(string)); err != nil {
+			fmt.Printf("%+v", err)
+			panic(err)
+		}
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: 204,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+		}, nil
+	} else if event.HTTPMethod == "POST" {
+		var input CreateProblemInput
+		if err := json.Unmarshal([]byte(event.Body), &input); err != nil {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+			}, nil
+		}
+
+		err := problemRepo.doCreate(event.RequestContext.Authorizer["sub"].(string), input)
+		if _, ok := err.(invalidInput); ok {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers: map[string]string{
+					"Access-Control-Allow-Origin": "*",
+				},
+				Body: err.Error(),
+			}, nil
+		} else if err != nil {
+			panic(err)
+		}
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: 201,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+		}, nil
+	} else if event.HTTPMethod == "GET" {
+		problems, err := problemRepo.doListWriterProblems(event.RequestContext.Authorizer["sub"].(string), true)
+		if err != nil {
+			panic(err)
+		}
+
+		body, _ := json.Marshal(problems)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+			Body: string(body),
+		}, nil
+	}
+
+	panic("unreachable")
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+func newTestRepo(t *testing.T, dir string) ProblemRepo {
+	problemTable, err := storage.NewFileTable(path.Join(dir, "problem.json"), "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	draftTable, err := storage.NewFileTable(path.Join(dir, "problem-draft.json"), "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewProblemRepo(storage.NewDirBlobStore(path.Join(dir, "blobs")), problemTable, draftTable)
+}
+
+func TestValidAttachmentFilename(t *testing.T) {
+	cases := []struct {
+		filename string
+		want     bool
+	}{
+		{"Defs.thy", true},
+		{".hidden", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"dir/Defs.thy", false},
+		{"../Defs.thy", false},
+		{`dir\Defs.thy`, false},
+	}
+
+	for _, c := range cases {
+		if got := validAttachmentFilename(c.filename); got != c.want {
+			t.Errorf("%q: got %v, want %v", c.filename, got, c.want)
+		}
+	}
+}
+
+func TestApplyAttachmentOperations(t *testing.T) {
+	files := model.LanguageFiles{Isabelle: []string{"A.thy", "B.thy"}}
+
+	cases := []struct {
+		name       string
+		operations []AttachmentOperation
+		want       model.LanguageFiles
+		err        bool
+	}{
+		{
+			name: "put, rename and delete",
+			operations: []AttachmentOperation{
+				{Op: AttachmentPut, Language: "isabelle", Filename: "C.thy"},
+				{Op: AttachmentPut, Language: "isabelle", Filename: "A.thy"},
+				{Op: AttachmentRename, Language: "isabelle", Filename: "B.thy", NewFilename: "D.thy"},
+				{Op: AttachmentDelete, Language: "isabelle", Filename: "A.thy"},
+				{Op: AttachmentPut, Language: "coq", Filename: "A.v"},
+			},
+			want: model.LanguageFiles{Isabelle: []string{"D.thy", "C.thy"}, Coq: []string{"A.v"}},
+		},
+		{
+			name:       "invalid filename",
+			operations: []AttachmentOperation{{Op: AttachmentPut, Language: "isabelle", Filename: "../A.thy"}},
+			err:        true,
+		},
+		{
+			name:       "rename to an existing attachment",
+			operations: []AttachmentOperation{{Op: AttachmentRename, Language: "isabelle", Filename: "A.thy", NewFilename: "B.thy"}},
+			err:        true,
+		},
+		{
+			name:       "rename to an invalid filename",
+			operations: []AttachmentOperation{{Op: AttachmentRename, Language: "isabelle", Filename: "A.thy", NewFilename: "x/B.thy"}},
+			err:        true,
+		},
+		{
+			name:       "delete a missing attachment",
+			operations: []AttachmentOperation{{Op: AttachmentDelete, Language: "isabelle", Filename: "C.thy"}},
+			err:        true,
+		},
+		{
+			name:       "unsupported language",
+			operations: []AttachmentOperation{{Op: AttachmentPut, Language: "python", Filename: "a.py"}},
+			err:        true,
+		},
+		{
+			name:       "unsupported operation",
+			operations: []AttachmentOperation{{Op: "copy", Language: "isabelle", Filename: "A.thy"}},
+			err:        true,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := applyAttachmentOperations(files, c.operations)
+		if (err != nil) != c.err {
+			t.Errorf("%s: got error %v", c.name, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+
+	if want := []string{"A.thy", "B.thy"}; !reflect.DeepEqual(files.Isabelle, want) {
+		t.Errorf("the operations changed the files: got %+v, want %+v", files.Isabelle, want)
+	}
+}
+
+func TestDoCreate(t *testing.T) {
+	cases := []struct {
+		name        string
+		attachments []Attachment
+		want        model.LanguageFiles
+		err         bool
+	}{
+		{
+			name: "attachments",
+			attachments: []Attachment{
+				{Code: "theory Defs", Filename: "Defs.thy", Language: "isabelle2021"},
+				{Code: "Definition x := 0.", Filename: "Defs.v", Language: "coq"},
+			},
+			want: model.LanguageFiles{Isabelle: []string{"Defs.thy"}, Coq: []string{"Defs.v"}, Lean4: []string{}},
+		},
+		{
+			name:        "invalid filename",
+			attachments: []Attachment{{Code: "theory Defs", Filename: "../Defs.thy", Language: "isabelle"}},
+			err:         true,
+		},
+		{
+			name: "unsupported language after a valid attachment",
+			attachments: []Attachment{
+				{Code: "theory Defs", Filename: "Defs.thy", Language: "isabelle"},
+				{Code: "x = 0", Filename: "defs.py", Language: "python"},
+			},
+			err: true,
+		},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "provenian-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		repo := newTestRepo(t, dir)
+		err = repo.doCreate("writer", CreateProblemInput{Title: "Problem", Attachments: c.attachments})
+		if _, ok := err.(invalidInput); ok != c.err {
+			t.Errorf("%s: got error %v", c.name, err)
+			continue
+		}
+
+		problems, err := repo.doListWriterProblems("writer", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.err {
+			if len(problems) > 0 {
+				t.Errorf("%s: created %+v", c.name, problems)
+			}
+			if _, err := os.Stat(path.Join(dir, "blobs")); !os.IsNotExist(err) {
+				t.Errorf("%s: saved the attachments", c.name)
+			}
+			continue
+		}
+
+		if len(problems) != 1 || !reflect.DeepEqual(problems[0].Files, c.want) {
+			t.Errorf("%s: got %+v, want the files %+v", c.name, problems, c.want)
+		}
+	}
+}
+
+func TestDoUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := newTestRepo(t, dir)
+	input := CreateProblemInput{Title: "Problem", ContentType: "text/markdown", Content: "Prove it", Limits: model.Limits{Time: 10}}
+	if err := repo.doCreate("writer", input); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := repo.doListWriterProblems("writer", true)
+	if err != nil || len(problems) != 1 {
+		t.Fatal(problems, err)
+	}
+	problemID := problems[0].ID
+
+	if err := repo.doUpdate(problemID, "other", UpdateProblemInput{}); err == nil {
+		t.Errorf("updated by another user")
+	}
+
+	title := "Renamed"
+	if err := repo.doUpdate(problemID, "writer", UpdateProblemInput{Title: &title}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.doGet(problemID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != title || got.ContentType != input.ContentType || got.Content != input.Content || got.Limits != input.Limits {
+		t.Errorf("got %+v, want the title %q with the rest kept", got, title)
+	}
+}
@@ -0,0 +1,328 @@
+package model
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The languages without versions, which the attachments, the goals and so on are given for
+var BaseLanguages = []string{"isabelle", "coq", "lean4"}
+
+// The versions following the language, made of digits and dots like 2019, 8.10 or .9.0 (for lean4.9.0)
+var versionPattern = regexp.MustCompile(`^[0-9.]*[0-9]$`)
+
+// BaseLanguage strips the version from the language.
+// Versioned languages are named by the language and the version of the prover, like isabelle2019,
+// and the language without a version is verified by the default version of the judge.
+// Anything else is returned as it is.
+func BaseLanguage(language string) string {
+	for _, base := range BaseLanguages {
+		if language == base || strings.HasPrefix(language, base) && versionPattern.MatchString(strings.TrimPrefix(language, base)) {
+			return base
+		}
+	}
+
+	return language
+}
+
+type LanguageFiles struct {
+	Isabelle []string `json:"isabelle" dynamo:"isabelle"`
+	Coq      []string `json:"coq" dynamo:"coq"`
+	Lean4    []string `json:"lean4" dynamo:"lean4"`
+}
+
+// Get returns the filenames of the language
+func (files LanguageFiles) Get(language string) []string {
+	switch BaseLanguage(language) {
+	case "isabelle":
+		return files.Isabelle
+	case "coq":
+		return files.Coq
+	case "lean4":
+		return files.Lean4
+	}
+
+	return nil
+}
+
+// Set replaces the filenames of the language, and reports whether the language is supported
+func (files *LanguageFiles) Set(language string, filenames []string) bool {
+	switch BaseLanguage(language) {
+	case "isabelle":
+		files.Isabelle = filenames
+	case "coq":
+		files.Coq = filenames
+	case "lean4":
+		files.Lean4 = filenames
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (files LanguageFiles) ListLanguages() []string {
+	var langs []string
+
+	if len(files.Isabelle) > 0 {
+		langs = append(langs, "isabelle")
+	}
+	if len(files.Coq) > 0 {
+		langs = append(langs, "coq")
+	}
+	if len(files.Lean4) > 0 {
+		langs = append(langs, "lean4")
+	}
+
+	return langs
+}
+
+// Goal is a theorem which submissions have to prove.
+// Statement is the proposition written in the language, as it appears in the theorem
+// (for Isabelle, the text between the quotes of `theorem goal: "..."`).
+type Goal struct {
+	Name      string `json:"name" dynamo:"name"`
+	Statement string `json:"statement" dynamo:"statement"`
+}
+
+type LanguageGoals struct {
+	Isabelle []Goal `json:"isabelle" dynamo:"isabelle"`
+	Coq      []Goal `json:"coq" dynamo:"coq"`
+	Lean4    []Goal `json:"lean4" dynamo:"lean4"`
+}
+
+func (goals LanguageGoals) Get(language string) []Goal {
+	switch BaseLanguage(language) {
+	case "isabelle":
+		return goals.Isabelle
+	case "coq":
+		return goals.Coq
+	case "lean4":
+		return goals.Lean4
+	}
+
+	return nil
+}
+
+// LanguageAxioms are the axioms and the oracles the proofs may depend on, besides the axioms
+// of the logic and of the problem. The names are qualified by their theories
+// (for Isabelle, like Submitted.choice for an axiom and SMT.cvc4 for an oracle),
+// and fully for Coq and Lean (like Coq.Logic.Classical_Prop.classic and Lean.ofReduceBool).
+type LanguageAxioms struct {
+	Isabelle []string `json:"isabelle" dynamo:"isabelle"`
+	Coq      []string `json:"coq" dynamo:"coq"`
+	Lean4    []string `json:"lean4" dynamo:"lean4"`
+}
+
+func (axioms LanguageAxioms) Get(language string) []string {
+	switch BaseLanguage(language) {
+	case "isabelle":
+		return axioms.Isabelle
+	case "coq":
+		return axioms.Coq
+	case "lean4":
+		return axioms.Lean4
+	}
+
+	return nil
+}
+
+// LanguageVersions are the versioned languages the problem supports, like isabelle2019.
+// Without them, the language is verified by the default version of the judge.
+type LanguageVersions struct {
+	Isabelle []string `json:"isabelle" dynamo:"isabelle"`
+	Coq      []string `json:"coq" dynamo:"coq"`
+	Lean4    []string `json:"lean4" dynamo:"lean4"`
+}
+
+// Validate checks that the versions are the versioned names of their languages
+func (versions LanguageVersions) Validate() error {
+	for _, base := range BaseLanguages {
+		for _, version := range versions.Get(base) {
+			if version == base || BaseLanguage(version) != base {
+				return errors.New("Invalid version of " + base + ": " + version)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (versions LanguageVersions) Get(language string) []string {
+	switch BaseLanguage(language) {
+	case "isabelle":
+		return versions.Isabelle
+	case "coq":
+		return versions.Coq
+	case "lean4":
+		return versions.Lean4
+	}
+
+	return nil
+}
+
+// LanguageTemplates are the code the submission box is prefilled with, by the languages.
+// The template of a language without the version is used for all of its versions, and
+// the templates of versions (like isabelle2019) are used for the language without the version
+// if the problem does not declare the versions.
+type LanguageTemplates map[string]string
+
+// Get returns the template of the language, or else the one without the version,
+// or else the one of the latest version
+func (templates LanguageTemplates) Get(language string) string {
+	if template, ok := templates[language]; ok {
+		return template
+	}
+
+	base := BaseLanguage(language)
+	if template, ok := templates[base]; ok {
+		return template
+	}
+
+	latest := ""
+	for name := range templates {
+		if BaseLanguage(name) == base && name > latest {
+			latest = name
+		}
+	}
+
+	return templates[latest]
+}
+
+// The maximum size of a template in bytes
+const MaxTemplateSize = 64 * 1024
+
+// Policy restricts the constructs the submissions may use. The zero value allows everything.
+// The judge enforces it on the Isabelle submissions.
+type Policy struct {
+	// ML blocks and the commands evaluating ML code (setup, method_setup, ...)
+	ForbidML bool `json:"forbid_ml" dynamo:"forbid_ml"`
+	// declare, including the configuration options set by declare [[...]]
+	ForbidDeclare bool `json:"forbid_declare" dynamo:"forbid_declare"`
+	// notation, syntax, translations and mixfix annotations
+	ForbidSyntax bool `json:"forbid_syntax" dynamo:"forbid_syntax"`
+	// Other commands the submissions may not use
+	ForbiddenCommands []string `json:"forbidden_commands" dynamo:"forbidden_commands"`
+	// The theories the submissions may import (like Main or HOL-Library.Multiset), any if empty.
+	// The theories of the problem and the other submitted theories are always allowed.
+	Imports []string `json:"imports" dynamo:"imports"`
+}
+
+// Limits are the resources a submission may use while it is verified.
+// Zero means the default of the judge.
+type Limits struct {
+	Time   int64 `json:"time" dynamo:"time"`     // in seconds
+	Memory int64 `json:"memory" dynamo:"memory"` // in megabytes
+}
+
+// IsabelleSession is the session the judge generates for the Isabelle submissions,
+// unless the attachments have their own ROOT file.
+// The attachment theories are built in the order of Theories (all of them by default)
+// in a session on top of Parent, then the submitted theories in the session Name.
+// Empty fields take the defaults of the judge.
+type IsabelleSession struct {
+	Name     string   `json:"name" dynamo:"name"`
+	Parent   string   `json:"parent" dynamo:"parent"`
+	Theories []string `json:"theories" dynamo:"theories"`
+}
+
+type Problem struct {
+	ID          string            `json:"id" dynamo:"id"`
+	Version     string            `json:"version" dynamo:"version"`
+	Title       string            `json:"title" dynamo:"title"`
+	ContentType string            `json:"content_type" dynamo:"-"`
+	Content     string            `json:"content" dynamo:"-"`
+	CreatedAt   int64             `json:"created_at" dynamo:"created_at"`
+	UpdatedAt   int64             `json:"updated_at" dynamo:"updated_at"`
+	Writer      string            `json:"writer" dynamo:"writer"`
+	Files       LanguageFiles     `json:"files" dynamo:"files"`
+	Goals       LanguageGoals     `json:"goals" dynamo:"goals"`
+	Limits      Limits            `json:"limits" dynamo:"limits"`
+	Axioms      LanguageAxioms    `json:"axioms" dynamo:"axioms"`
+	Versions    LanguageVersions  `json:"versions" dynamo:"versions"`
+	Templates   LanguageTemplates `json:"template" dynamo:"-"`
+	Policy      Policy            `json:"policy" dynamo:"policy"`
+	Isabelle    IsabelleSession   `json:"isabelle" dynamo:"isabelle"`
+	Languages   []string          `json:"languages" dynamo:"-"`
+}
+
+func NewProblem(id string, title string, contentType string, content string, userID string, files LanguageFiles, goals LanguageGoals, limits Limits) Problem {
+	return Problem{
+		ID:          id,
+		Version:     "1.0",
+		Title:       title,
+		ContentType: contentType,
+		Content:     content,
+		UpdatedAt:   time.Now().Unix(),
+		CreatedAt:   time.Now().Unix(),
+		Writer:      userID,
+		Files:       files,
+		Goals:       goals,
+		Limits:      limits,
+		Languages:   files.ListLanguages(),
+	}
+}
+
+// SupportedLanguages are the languages the submissions may use: the declared versions of
+// the languages having attachments, or the languages themselves if no versions are declared
+func (problem Problem) SupportedLanguages() []string {
+	var languages []string
+	for _, language := range problem.Files.ListLanguages() {
+		if versions := problem.Versions.Get(language); len(versions) > 0 {
+			languages = append(languages, versions...)
+		} else {
+			languages = append(languages, language)
+		}
+	}
+
+	return languages
+}
+
+// Supports tells whether the submissions may use the language, i.e. it is one of the supported languages.
+// The languages without attachments are not supported, since the problem has no goals for them.
+func (problem Problem) Supports(language string) bool {
+	for _, supported := range problem.SupportedLanguages() {
+		if supported == language {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateTemplates checks that the templates are for the languages the problem accepts
+func (problem Problem) ValidateTemplates() error {
+	for language, template := range problem.Templates {
+		base := BaseLanguage(language)
+		known := false
+		for _, name := range BaseLanguages {
+			if name == base {
+				known = true
+			}
+		}
+		if !known {
+			return errors.New("Unsupported language in the templates: " + language)
+		}
+
+		// Any version is fine unless the problem declares the versions
+		if versions := problem.Versions.Get(base); language != base && len(versions) > 0 {
+			declared := false
+			for _, version := range versions {
+				if version == language {
+					declared = true
+				}
+			}
+			if !declared {
+				return errors.New("The problem does not support the language of the template: " + language)
+			}
+		}
+
+		if len(template) > MaxTemplateSize {
+			return errors.New("Too large template: " + language)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBaseLanguage(t *testing.T) {
+	cases := []struct {
+		language string
+		want     string
+	}{
+		{"isabelle", "isabelle"},
+		{"isabelle2019", "isabelle"},
+		{"coq8.10", "coq"},
+		{"lean4", "lean4"},
+		{"lean4.9.0", "lean4"},
+		{"python", "python"},
+		{"isabellefoo", "isabellefoo"},
+		{"isabelle2019a", "isabelle2019a"},
+		{"isabelle.", "isabelle."},
+		{"coq-8.10", "coq-8.10"},
+	}
+
+	for _, c := range cases {
+		if got := BaseLanguage(c.language); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.language, got, c.want)
+		}
+	}
+}
+
+func TestLanguageVersionsValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		versions LanguageVersions
+		valid    bool
+	}{
+		{"none", LanguageVersions{}, true},
+		{"versions", LanguageVersions{Isabelle: []string{"isabelle2019", "isabelle2021"}, Coq: []string{"coq8.10"}}, true},
+		{"without the version", LanguageVersions{Isabelle: []string{"isabelle"}}, false},
+		{"another language", LanguageVersions{Isabelle: []string{"coq8.10"}}, false},
+		{"not a version", LanguageVersions{Lean4: []string{"lean4nightly"}}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.versions.Validate(); (err == nil) != c.valid {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	problem := Problem{
+		Files:    LanguageFiles{Isabelle: []string{"Defs.thy"}, Coq: []string{"Defs.v"}},
+		Versions: LanguageVersions{Isabelle: []string{"isabelle2019", "isabelle2020"}},
+	}
+
+	cases := []struct {
+		name      string
+		templates LanguageTemplates
+		valid     bool
+	}{
+		{"none", nil, true},
+		{"base languages", LanguageTemplates{"isabelle": "theory Submitted", "coq": "Theorem"}, true},
+		{"declared version", LanguageTemplates{"isabelle2019": "theory Submitted"}, true},
+		{"undeclared version", LanguageTemplates{"isabelle2018": "theory Submitted"}, false},
+		{"version without declared versions", LanguageTemplates{"coq8.10": "Theorem"}, true},
+		{"unknown language", LanguageTemplates{"python": "print()"}, false},
+		{"too large", LanguageTemplates{"coq": strings.Repeat("x", MaxTemplateSize+1)}, false},
+	}
+
+	for _, c := range cases {
+		problem.Templates = c.templates
+		if err := problem.ValidateTemplates(); (err == nil) != c.valid {
+			t.Errorf("%s: got %v, want valid %v", c.name, err, c.valid)
+		}
+	}
+}
+
+func TestLanguageTemplatesGet(t *testing.T) {
+	templates := LanguageTemplates{
+		"isabelle":     "base",
+		"isabelle2019": "2019",
+		"coq8.10":      "8.10",
+		"coq8.11":      "8.11",
+	}
+
+	cases := []struct {
+		language string
+		want     string
+	}{
+		{"isabelle2019", "2019"},
+		{"isabelle2020", "base"},
+		{"isabelle", "base"},
+		{"coq", "8.11"},
+		{"coq8.10", "8.10"},
+		{"lean4", ""},
+	}
+
+	for _, c := range cases {
+		if got := templates.Get(c.language); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.language, got, c.want)
+		}
+	}
+}
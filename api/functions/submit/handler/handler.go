@@ -0,0 +1,503 @@
+// Package handler implements the submission API, independent of where it is deployed
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/satori/go.uuid"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/functions/submit/model"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+type SubmitRepo struct {
+	table storage.Table
+	blobs storage.BlobStore
+	// The public URL of the blob store, where the logs are downloaded from
+	storageURL string
+}
+
+func NewSubmitRepo(table storage.Table, blobs storage.BlobStore, storageURL string) SubmitRepo {
+	return SubmitRepo{
+		table:      table,
+		blobs:      blobs,
+		storageURL: storageURL,
+	}
+}
+
+func (repo SubmitRepo) Create(submission model.Submission) (model.Submission, error) {
+	submission.ID = uuid.NewV4().String()
+	submission.CreatedAt = time.Now().Unix()
+
+	codeFilePath := submission.ProblemID + "/submissions/" + submission.ID
+	if err := repo.blobs.Put(codeFilePath, strings.NewReader(submission.Code), "public, max-age=86400"); err != nil {
+		return model.Submission{}, err
+	}
+
+	submission.Code = codeFilePath
+
+	// The files come with their contents, which are replaced by their keys
+	for index, file := range submission.Files {
+		filePath := codeFilePath + ".files/" + file.Filename
+		if err := repo.blobs.Put(filePath, strings.NewReader(file.Code), "public, max-age=86400"); err != nil {
+			return model.Submission{}, err
+		}
+
+		submission.Files[index].Code = filePath
+	}
+
+	submission.Phases = []model.Phase{model.NewPhase(model.PhaseQueued)}
+
+	if err := repo.table.Put(submission); err != nil {
+		return model.Submission{}, err
+	}
+
+	return submission, nil
+}
+
+// GetProblem reads the published problem
+func (repo SubmitRepo) GetProblem(problemID string) (problemmodel.Problem, error) {
+	body, err := repo.blobs.Get(problemID + ".json")
+	if err != nil {
+		return problemmodel.Problem{}, err
+	}
+	defer body.Close()
+
+	var problem problemmodel.Problem
+	if err := json.NewDecoder(body).Decode(&problem); err != nil {
+		return problemmodel.Problem{}, err
+	}
+
+	return problem, nil
+}
+
+// IsWriter tells whether the user is the writer of the published problem
+func (repo SubmitRepo) IsWriter(problemID string, userID string) (bool, error) {
+	problem, err := repo.GetProblem(problemID)
+	if err == storage.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return problem.Writer == userID, nil
+}
+
+// Get method returns submission by ID
+// Result will be wj if the status is "Wait for Judge", and jg while the judge is working on it
+func (repo SubmitRepo) Get(ID string) (model.Submission, error) {
+	var submission model.Submission
+	if err := repo.table.Get("id", ID, &submission); err != nil {
+		return model.Submission{}, err
+	}
+
+	submission.Result = submission.Status()
+
+	return submission, nil
+}
+
+func (repo SubmitRepo) ListByProblemID(ID string) ([]model.Submission, error) {
+	var submissions []model.Submission
+	if err := repo.table.Query("problems", "problem_id", ID, &submissions); err != nil {
+		return nil, err
+	}
+
+	for index, submission := range submissions {
+		submissions[index].Result = submission.Status()
+	}
+
+	return submissions, nil
+}
+
+// ListByResultCode returns the finished submissions having the result code
+func (repo SubmitRepo) ListByResultCode(code string) ([]model.Submission, error) {
+	var submissions []model.Submission
+	if err := repo.table.Scan(&submissions); err != nil {
+		return nil, err
+	}
+
+	var matched []model.Submission
+	for _, submission := range submissions {
+		if submission.Result.Code == code {
+			submission.Result = submission.Status()
+			matched = append(matched, submission)
+		}
+	}
+
+	return matched, nil
+}
+
+// Rejudge moves the result of the submission to its history, so that the judge verifies it again
+func (repo SubmitRepo) Rejudge(submission model.Submission) (model.Submission, error) {
+	submission = submission.Rejudge()
+	if err := repo.table.Put(submission); err != nil {
+		return model.Submission{}, err
+	}
+
+	return submission, nil
+}
+
+type JobQueue struct {
+	queue storage.Queue
+}
+
+func NewJobQueue(queue storage.Queue) JobQueue {
+	return JobQueue{
+		queue: queue,
+	}
+}
+
+func (queue JobQueue) Push(message string) error {
+	return queue.queue.Push(message)
+}
+
+// ---
+
+func doPost(submitRepo SubmitRepo, queue JobQueue, submissionInput model.Submission) (events.APIGatewayProxyResponse, error) {
+	submission, err := submitRepo.Create(submissionInput)
+	if err != nil {
+		panic(err)
+	}
+
+	err = queue.Push(submission.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	body, err := json.Marshal(submission)
+	if err != nil {
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: string(body),
+	}, nil
+}
+
+func doGet(submitRepo SubmitRepo, submissionID string) (events.APIGatewayProxyResponse, error) {
+	submission, err := submitRepo.Get(submissionID)
+	if err != nil {
+		panic(err)
+	}
+
+	body, err := json.Marshal(submission)
+	if err != nil {
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: string(body),
+	}, nil
+}
+
+// doGetLog redirects to the full log in the blob store, which can be too large for the response of the API.
+// The message of the result is the full log if it has no log.
+func doGetLog(submitRepo SubmitRepo, submissionID string) (events.APIGatewayProxyResponse, error) {
+	submission, err := submitRepo.Get(submissionID)
+	if err != nil {
+		panic(err)
+	}
+
+	if submission.Result.LogKey != "" {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 302,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+				"Location":                    submitRepo.storageURL + "/" + submission.Result.LogKey,
+			},
+		}, nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+			"Content-Type":                "text/plain; charset=utf-8",
+		},
+		Body: submission.Result.Message,
+	}, nil
+}
+
+func doList(submitRepo SubmitRepo, problemID string) (events.APIGatewayProxyResponse, error) {
+	submissions, err := submitRepo.ListByProblemID(problemID)
+	if err != nil {
+		panic(err)
+	}
+
+	body, err := json.Marshal(submissions)
+	if err != nil {
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: string(body),
+	}, nil
+}
+
+// Rejudge queues the finished ones of the submissions again, and returns them.
+// The submissions not finished yet are left to the judge working on them.
+func Rejudge(submitRepo SubmitRepo, queue JobQueue, submissions []model.Submission) ([]model.Submission, error) {
+	rejudged := []model.Submission{}
+	for _, submission := range submissions {
+		if !submission.Result.IsFinished {
+			continue
+		}
+
+		submission, err := submitRepo.Rejudge(submission)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := queue.Push(submission.ID); err != nil {
+			return nil, err
+		}
+
+		submission.Result = submission.Status()
+		rejudged = append(rejudged, submission)
+	}
+
+	return rejudged, nil
+}
+
+func doRejudge(submitRepo SubmitRepo, queue JobQueue, submissionID string, userID string) (events.APIGatewayProxyResponse, error) {
+	submission, err := submitRepo.Get(submissionID)
+	if err != nil {
+		panic(err)
+	}
+
+	writer, err := submitRepo.IsWriter(submission.ProblemID, userID)
+	if err != nil {
+		panic(err)
+	}
+	if !writer {
+		return forbidden(), nil
+	}
+
+	rejudged, err := Rejudge(submitRepo, queue, []model.Submission{submission})
+	if err != nil {
+		panic(err)
+	}
+
+	if len(rejudged) == 0 {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 409,
+			Headers: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+		}, nil
+	}
+
+	body, err := json.Marshal(rejudged[0])
+	if err != nil {
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: string(body),
+	}, nil
+}
+
+func doRejudgeAll(submitRepo SubmitRepo, queue JobQueue, submissions []model.Submission) (events.APIGatewayProxyResponse, error) {
+	rejudged, err := Rejudge(submitRepo, queue, submissions)
+	if err != nil {
+		panic(err)
+	}
+
+	body, err := json.Marshal(rejudged)
+	if err != nil {
+		panic(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+		Body: string(body),
+	}, nil
+}
+
+func forbidden() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		StatusCode: 403,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+	}
+}
+
+type SubmitInput struct {
+	Language string `json:"language"`
+	Code     string `json:"code"`
+	// Files other than the main code, if the language accepts them
+	Files []model.SubmissionFile `json:"files"`
+}
+
+// validFilename tells whether the filename can be used as the last segment of a key
+func validFilename(filename string) bool {
+	return filename != "" && filename != "." && filename != ".." && !strings.ContainsAny(filename, "/\\")
+}
+
+// RejudgeInput selects the submissions to rejudge by their result code.
+// Every finished submission of the problem is rejudged if it is empty.
+type RejudgeInput struct {
+	ResultCode string `json:"result_code"`
+}
+
+// Handle handles the request proxied by API Gateway.
+// The authorizer context must have the user ID as `sub` for submitting.
+// Rejudging is allowed only for the writers by the authorizer, on their own problems.
+func Handle(submitRepo SubmitRepo, jobQueue JobQueue, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if event.Resource == "/submissions/{submissionId}/rejudge" && event.HTTPMethod == "POST" {
+		return doRejudge(submitRepo, jobQueue, event.PathParameters["submissionId"], event.RequestContext.Authorizer["sub"].(string))
+	} else if event.Resource == "/problems/{problemId}/rejudge" && event.HTTPMethod == "POST" {
+		var input RejudgeInput
+		if event.Body != "" {
+			if err := json.Unmarshal([]byte(event.Body), &input); err != nil {
+				panic(err)
+			}
+		}
+
+		writer, err := submitRepo.IsWriter(event.PathParameters["problemId"], event.RequestContext.Authorizer["sub"].(string))
+		if err != nil {
+			panic(err)
+		}
+		if !writer {
+			return forbidden(), nil
+		}
+
+		submissions, err := submitRepo.ListByProblemID(event.PathParameters["problemId"])
+		if err != nil {
+			panic(err)
+		}
+
+		var selected []model.Submission
+		for _, submission := range submissions {
+			if input.ResultCode == "" || submission.Result.Code == input.ResultCode {
+				selected = append(selected, submission)
+			}
+		}
+
+		return doRejudgeAll(submitRepo, jobQueue, selected)
+	} else if event.Resource == "/submissions/rejudge" && event.HTTPMethod == "POST" {
+		var input RejudgeInput
+		if err := json.Unmarshal([]byte(event.Body), &input); err != nil {
+			panic(err)
+		}
+
+		// Rejudging every submission at once is not allowed
+		if input.ResultCode == "" {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers: map[string]string{
+					"Access-Control-Allow-Origin": "*",
+				},
+			}, nil
+		}
+
+		submissions, err := submitRepo.ListByResultCode(input.ResultCode)
+		if err != nil {
+			panic(err)
+		}
+
+		// Only the submissions of the problems of the writer
+		writers := map[string]bool{}
+		var selected []model.Submission
+		for _, submission := range submissions {
+			writer, ok := writers[submission.ProblemID]
+			if !ok {
+				writer, err = submitRepo.IsWriter(submission.ProblemID, event.RequestContext.Authorizer["sub"].(string))
+				if err != nil {
+					panic(err)
+				}
+				writers[submission.ProblemID] = writer
+			}
+
+			if writer {
+				selected = append(selected, submission)
+			}
+		}
+
+		return doRejudgeAll(submitRepo, jobQueue, selected)
+	} else if event.HTTPMethod == "POST" {
+		var input SubmitInput
+		if err := json.Unmarshal([]byte(event.Body), &input); err != nil {
+			panic(err)
+		}
+
+		seen := map[string]bool{}
+		for _, file := range input.Files {
+			if !validFilename(file.Filename) || seen[file.Filename] {
+				return events.APIGatewayProxyResponse{
+					StatusCode: 400,
+					Headers: map[string]string{
+						"Access-Control-Allow-Origin": "*",
+					},
+				}, nil
+			}
+
+			seen[file.Filename] = true
+		}
+
+		problem, err := submitRepo.GetProblem(event.PathParameters["problemId"])
+		if err == storage.ErrNotFound {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Headers: map[string]string{
+					"Access-Control-Allow-Origin": "*",
+				},
+			}, nil
+		} else if err != nil {
+			panic(err)
+		}
+
+		// The judge rejects it as well, but it is never queued
+		if !problem.Supports(input.Language) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Headers: map[string]string{
+					"Access-Control-Allow-Origin": "*",
+				},
+				Body: "This problem does not support " + input.Language,
+			}, nil
+		}
+
+		submission := model.Submission{
+			ProblemID: event.PathParameters["problemId"],
+			Code:      input.Code,
+			Files:     input.Files,
+			UserID:    event.RequestContext.Authorizer["sub"].(string),
+			Language:  input.Language,
+		}
+
+		return doPost(submitRepo, jobQueue, submission)
+	} else if problemID, ok := event.PathParameters["problemId"]; event.HTTPMethod == "GET" && ok {
+		return doList(submitRepo, problemID)
+	} else if event.Resource == "/submissions/{submissionId}/log" && event.HTTPMethod == "GET" {
+		return doGetLog(submitRepo, event.PathParameters["submissionId"])
+	} else if submissionID, ok := event.PathParameters["submissionId"]; event.HTTPMethod == "GET" && ok {
+		return doGet(submitRepo, submissionID)
+	}
+
+	panic("unreachable")
+}
@@ -1,10 +1,52 @@
 package model
 
+import (
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Result of the judge. Message may be an excerpt of the log,
+// the full log is then stored in the blob store at LogKey.
 type Result struct {
-	Code       string `dynamo:"status_code" json:"status_code"`
-	Text       string `dynamo:"status_text" json:"status_text"`
-	Message    string `dynamo:"message" json:"message"`
-	IsFinished bool   `dynamo:"-" json:"is_finished"`
+	Code        string       `dynamo:"status_code" json:"status_code"`
+	Text        string       `dynamo:"status_text" json:"status_text"`
+	Message     string       `dynamo:"message" json:"message"`
+	LogKey      string       `dynamo:"log_key,omitempty" json:"log_key,omitempty"`
+	Diagnostics []Diagnostic `dynamo:"diagnostics,omitempty" json:"diagnostics,omitempty"`
+	// The axioms and the oracles the verified proof rests on
+	Dependencies []Dependency `dynamo:"dependencies,omitempty" json:"dependencies,omitempty"`
+	IsFinished   bool         `dynamo:"-" json:"is_finished"`
+}
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is a message of the proof assistant at a position of the submission.
+// Line and Column start from 1, and are 0 if unknown.
+type Diagnostic struct {
+	Theory   string `dynamo:"theory" json:"theory"`
+	Line     int    `dynamo:"line" json:"line"`
+	Column   int    `dynamo:"column" json:"column"`
+	Severity string `dynamo:"severity" json:"severity"`
+	Message  string `dynamo:"message" json:"message"`
+}
+
+const (
+	DependencyAxiom  = "axiom"
+	DependencyOracle = "oracle"
+)
+
+// Dependency is an axiom or an oracle in the proof of the submission.
+// Theorem is the goal depending on it, or empty for the axioms the submission declares.
+// Allowed tells whether the problem allows it.
+type Dependency struct {
+	Kind    string `dynamo:"kind" json:"kind"`
+	Name    string `dynamo:"name" json:"name"`
+	Theorem string `dynamo:"theorem" json:"theorem"`
+	Allowed bool   `dynamo:"allowed" json:"allowed"`
 }
 
 func WJ() Result {
@@ -15,6 +57,14 @@ func WJ() Result {
 	}
 }
 
+func JG() Result {
+	return Result{
+		Code:       "JG",
+		Text:       "Judging...",
+		IsFinished: false,
+	}
+}
+
 func CE(message string) Result {
 	return Result{
 		Code:       "CE",
@@ -33,12 +83,176 @@ func V(message string) Result {
 	}
 }
 
+func CD(message string) Result {
+	return Result{
+		Code:       "CD",
+		Text:       "Cheat Detected",
+		Message:    message,
+		IsFinished: true,
+	}
+}
+
+func PV(message string) Result {
+	return Result{
+		Code:       "PV",
+		Text:       "Policy Violation",
+		Message:    message,
+		IsFinished: true,
+	}
+}
+
+func WS(message string) Result {
+	return Result{
+		Code:       "WS",
+		Text:       "Wrong Statement",
+		Message:    message,
+		IsFinished: true,
+	}
+}
+
+func TLE(message string) Result {
+	return Result{
+		Code:       "TLE",
+		Text:       "Time Limit Exceeded",
+		Message:    message,
+		IsFinished: true,
+	}
+}
+
+func MLE(message string) Result {
+	return Result{
+		Code:       "MLE",
+		Text:       "Memory Limit Exceeded",
+		Message:    message,
+		IsFinished: true,
+	}
+}
+
+func IE(message string) Result {
+	return Result{
+		Code:       "IE",
+		Text:       "Internal Error",
+		Message:    message,
+		IsFinished: true,
+	}
+}
+
+// The phases of judging a submission, in order
+const (
+	PhaseQueued   = "queued"
+	PhaseFetching = "fetching"
+	PhaseBuilding = "building"
+	PhaseChecking = "checking"
+	PhaseFinished = "finished"
+)
+
+// Phase is a step the submission went through, StartedAt is the unix time in milliseconds
+type Phase struct {
+	Name      string `dynamo:"name" json:"name"`
+	StartedAt int64  `dynamo:"started_at" json:"started_at"`
+}
+
+func NewPhase(name string) Phase {
+	return Phase{
+		Name:      name,
+		StartedAt: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// SubmissionFile is a file submitted in addition to the main code, like a theory of helper lemmas.
+// Code is the key of the file in the blob store.
+type SubmissionFile struct {
+	Filename string `dynamo:"filename" json:"filename"`
+	Code     string `dynamo:"code" json:"code"`
+}
+
 type Submission struct {
-	ID        string `dynamo:"id" json:"id"`
-	CreatedAt int64  `dynamo:"created_at" json:"created_at"`
-	ProblemID string `dynamo:"problem_id" json:"problem_id"`
-	Code      string `dynamo:"code" json:"code"`
-	Language  string `dynamo:"language" json:"language"`
-	UserID    string `dynamo:"user_id" json:"user_id"`
-	Result    Result `dynamo:"result" json:"result"`
+	ID        string           `dynamo:"id" json:"id"`
+	CreatedAt int64            `dynamo:"created_at" json:"created_at"`
+	ProblemID string           `dynamo:"problem_id" json:"problem_id"`
+	Code      string           `dynamo:"code" json:"code"`
+	Files     []SubmissionFile `dynamo:"files,omitempty" json:"files,omitempty"`
+	Language  string           `dynamo:"language" json:"language"`
+	UserID    string           `dynamo:"user_id" json:"user_id"`
+	Result    Result           `dynamo:"result" json:"result"`
+	Phases    []Phase          `dynamo:"phases" json:"phases"`
+	// The previous judgements, if the submission is rejudged. Only the latest maxHistory are kept.
+	History []Judgement `dynamo:"history,omitempty" json:"history,omitempty"`
+	// How many times the submission is rejudged
+	Rejudged int `dynamo:"rejudged,omitempty" json:"rejudged,omitempty"`
+}
+
+// The judgements kept in the history, and the length of their messages.
+// Their diagnostics and dependencies are dropped, which are in their logs anyway.
+const maxHistory = 10
+const historyMessageLength = 1024
+
+// Judgement is a previous result of a rejudged submission
+type Judgement struct {
+	Result     Result  `dynamo:"result" json:"result"`
+	Phases     []Phase `dynamo:"phases" json:"phases"`
+	RejudgedAt int64   `dynamo:"rejudged_at" json:"rejudged_at"`
+}
+
+// Judging tells whether the judge has started on the submission and not finished yet
+func (submission Submission) Judging() bool {
+	if submission.Result.Code != "" || len(submission.Phases) == 0 {
+		return false
+	}
+
+	return submission.Phases[len(submission.Phases)-1].Name != PhaseQueued
+}
+
+// LogKey is where the judge stores the full log of the submission.
+// The logs of the previous judgements are kept under their own keys.
+func (submission Submission) LogKey() string {
+	if submission.Rejudged > 0 {
+		return submission.ProblemID + "/submissions/" + submission.ID + "." + strconv.Itoa(submission.Rejudged) + ".log"
+	}
+
+	return submission.ProblemID + "/submissions/" + submission.ID + ".log"
+}
+
+// Rejudge moves the result to the history, and the submission waits for the judge again
+func (submission Submission) Rejudge() Submission {
+	result := submission.Result
+	result.Diagnostics = nil
+	result.Dependencies = nil
+	if len(result.Message) > historyMessageLength {
+		start := len(result.Message) - historyMessageLength
+		for start < len(result.Message) && !utf8.RuneStart(result.Message[start]) {
+			start++
+		}
+		result.Message = result.Message[start:]
+	}
+
+	history := submission.History
+	if len(history) >= maxHistory {
+		history = history[len(history)-maxHistory+1:]
+	}
+
+	submission.History = append(append([]Judgement{}, history...), Judgement{
+		Result:     result,
+		Phases:     submission.Phases,
+		RejudgedAt: time.Now().Unix(),
+	})
+	submission.Rejudged++
+	submission.Result = Result{}
+	submission.Phases = []Phase{NewPhase(PhaseQueued)}
+
+	return submission
+}
+
+// Status returns the result, or WJ/JG if the submission is not finished yet
+func (submission Submission) Status() Result {
+	if submission.Result.Code != "" {
+		submission.Result.IsFinished = true
+		return submission.Result
+	}
+
+	if submission.Judging() {
+		return JG()
+	}
+
+	return WJ()
 }
@@ -80,7 +80,13 @@ const lambdaRole = (() => {
       .getPolicyDocument({
         statements: [
           {
-            actions: ["sqs:*", "s3:*", "dynamodb:*", "logs:*"],
+            actions: [
+              "sqs:*",
+              "s3:*",
+              "dynamodb:*",
+              "logs:*",
+              "execute-api:ManageConnections"
+            ],
             effect: "Allow",
             resources: ["*"]
           }
@@ -124,6 +130,122 @@ const judgeQueue = new aws.sqs.Queue("judge-queue", {
   name: `${config.service}-${config.stage}-judge-queue`
 });
 
+const judgeDeadLetterQueue = new aws.sqs.Queue("judge-dead-letter-queue", {
+  name: `${config.service}-${config.stage}-judge-dead-letter-queue`,
+  messageRetentionSeconds: 1209600
+});
+
+// The judge publishes the status transitions of the submissions to this topic
+const judgeNotifyTopic = new aws.sns.Topic("judge-notify-topic", {
+  name: `${config.service}-${config.stage}-judge-notify`
+});
+
+// The WebSocket API pushing the events of the topic to the clients (see functions/events)
+const socketApi = new aws.apigatewayv2.Api("socket-api", {
+  name: `${config.service}-${config.stage}-socket`,
+  protocolType: "WEBSOCKET",
+  routeSelectionExpression: "$request.body.action"
+});
+
+const connectionTable = new aws.dynamodb.Table("connection", {
+  billingMode: "PAY_PER_REQUEST",
+  name: `${config.service}-${config.stage}-connection`,
+  attributes: [
+    {
+      name: "id",
+      type: "S"
+    },
+    {
+      name: "submission_id",
+      type: "S"
+    },
+    {
+      name: "user_id",
+      type: "S"
+    }
+  ],
+  hashKey: "id",
+  ttl: {
+    attributeName: "expires_at",
+    enabled: true
+  },
+  globalSecondaryIndexes: [
+    {
+      name: "submission_id",
+      hashKey: "submission_id",
+      projectionType: "ALL"
+    },
+    {
+      name: "user_id",
+      hashKey: "user_id",
+      projectionType: "ALL"
+    }
+  ]
+});
+
+const eventsHandler = pulumi_extra.lambda.createLambdaFunction("events", {
+  filepath: "events",
+  handlerName: `${config.service}-${config.stage}-events`,
+  role: lambdaRole,
+  lambdaOptions: {
+    environment: {
+      variables: {
+        connectionTableName: connectionTable.name,
+        socketEndpoint: pulumi.interpolate`https://${socketApi.id}.execute-api.ap-northeast-1.amazonaws.com/${config.stage}`
+      }
+    }
+  }
+});
+
+const socketStage = (() => {
+  const integration = new aws.apigatewayv2.Integration("socket-integration", {
+    apiId: socketApi.id,
+    integrationType: "AWS_PROXY",
+    integrationUri: eventsHandler.invokeArn
+  });
+
+  const routes = ["$connect", "$disconnect"].map(
+    routeKey =>
+      new aws.apigatewayv2.Route(`socket-route-${routeKey.slice(1)}`, {
+        apiId: socketApi.id,
+        routeKey,
+        target: pulumi.interpolate`integrations/${integration.id}`
+      })
+  );
+
+  new aws.lambda.Permission("events-socket-permission", {
+    action: "lambda:InvokeFunction",
+    function: eventsHandler.name,
+    principal: "apigateway.amazonaws.com",
+    sourceArn: pulumi.interpolate`${socketApi.executionArn}/*/*`
+  });
+
+  return new aws.apigatewayv2.Stage(
+    "socket-stage",
+    {
+      apiId: socketApi.id,
+      name: config.stage,
+      autoDeploy: true
+    },
+    {
+      dependsOn: routes
+    }
+  );
+})();
+
+new aws.lambda.Permission("events-topic-permission", {
+  action: "lambda:InvokeFunction",
+  function: eventsHandler.name,
+  principal: "sns.amazonaws.com",
+  sourceArn: judgeNotifyTopic.arn
+});
+
+new aws.sns.TopicSubscription("events-topic-subscription", {
+  topic: judgeNotifyTopic,
+  protocol: "lambda",
+  endpoint: eventsHandler.arn
+});
+
 const api = new aws.apigateway.RestApi("api", {
   name: `${config.service}-${config.stage}`
 });
@@ -205,7 +327,8 @@ const submitHandler = pulumi_extra.lambda.createLambdaFunction("submit", {
       variables: {
         submitTableName: submitTable.name,
         judgeQueueName: judgeQueue.name,
-        storageBucketName: storageBucket.bucket
+        storageBucketName: storageBucket.bucket,
+        storageURL: pulumi.interpolate`https://${storageBucket.bucketRegionalDomainName}`
       }
     }
   }
@@ -409,19 +532,17 @@ const listSubmissionAPI = pulumi_extra.apigateway.createLambdaMethod(
   }
 );
 
-const getSubmissionAPI = (() => {
-  const submissions = new aws.apigateway.Resource("submissions", {
-    parentId: api.rootResourceId,
-    pathPart: "submissions",
-    restApi: api
-  });
-
-  return pulumi_extra.apigateway.createLambdaMethod("get-submit", {
-    authorization: "NONE",
-    httpMethod: "GET",
-    resource: createCORSResource("submissions-id", {
-      parentId: submissions.id,
-      pathPart: "{submissionId}",
+const rejudgeProblemAPI = pulumi_extra.apigateway.createLambdaMethod(
+  "rejudge-problem",
+  {
+    authorization: "CUSTOM",
+    method: {
+      authorizerId: authorizer.id
+    },
+    httpMethod: "POST",
+    resource: createCORSResource("problems-rejudge", {
+      parentId: problemIdResource.id,
+      pathPart: "rejudge",
       restApi: api
     }),
     restApi: api,
@@ -429,7 +550,85 @@ const getSubmissionAPI = (() => {
       type: "AWS_PROXY"
     },
     handler: submitHandler
+  }
+);
+
+const getSubmissionAPI = (() => {
+  const submissions = new aws.apigateway.Resource("submissions", {
+    parentId: api.rootResourceId,
+    pathPart: "submissions",
+    restApi: api
   });
+
+  const submissionId = createCORSResource("submissions-id", {
+    parentId: submissions.id,
+    pathPart: "{submissionId}",
+    restApi: api
+  });
+
+  return {
+    getSubmission: pulumi_extra.apigateway.createLambdaMethod("get-submit", {
+      authorization: "NONE",
+      httpMethod: "GET",
+      resource: submissionId,
+      restApi: api,
+      integration: {
+        type: "AWS_PROXY"
+      },
+      handler: submitHandler
+    }),
+    getLog: pulumi_extra.apigateway.createLambdaMethod("get-submit-log", {
+      authorization: "NONE",
+      httpMethod: "GET",
+      resource: createCORSResource("submissions-log", {
+        parentId: submissionId.id,
+        pathPart: "log",
+        restApi: api
+      }),
+      restApi: api,
+      integration: {
+        type: "AWS_PROXY"
+      },
+      handler: submitHandler
+    }),
+    rejudge: pulumi_extra.apigateway.createLambdaMethod("rejudge-submit", {
+      authorization: "CUSTOM",
+      method: {
+        authorizerId: authorizer.id
+      },
+      httpMethod: "POST",
+      resource: createCORSResource("submissions-rejudge", {
+        parentId: submissionId.id,
+        pathPart: "rejudge",
+        restApi: api
+      }),
+      restApi: api,
+      integration: {
+        type: "AWS_PROXY"
+      },
+      handler: submitHandler
+    }),
+    rejudgeByResult: pulumi_extra.apigateway.createLambdaMethod(
+      "rejudge-submit-by-result",
+      {
+        authorization: "CUSTOM",
+        method: {
+          authorizerId: authorizer.id
+        },
+        httpMethod: "POST",
+        resource: createCORSResource("submissions-rejudge-by-result", {
+          parentId: submissions.id,
+          pathPart: "rejudge",
+          restApi: api
+        }),
+        restApi: api,
+        integration: {
+          type: "AWS_PROXY"
+        },
+        handler: submitHandler
+      }
+    )
+  };
 })();
 
 const apiDeployment = new aws.apigateway.Deployment(
@@ -442,7 +641,11 @@ const apiDeployment = new aws.apigateway.Deployment(
   {
     dependsOn: [
       submitAPI,
-      getSubmissionAPI,
+      getSubmissionAPI.getSubmission,
+      getSubmissionAPI.getLog,
+      getSubmissionAPI.rejudge,
+      getSubmissionAPI.rejudgeByResult,
+      rejudgeProblemAPI,
       listSubmissionAPI,
       editProblemAPI,
       createProblemAPI,
@@ -456,5 +659,8 @@ export const output = {
   restApi: apiDeployment.invokeUrl,
   submitTableName: submitTable.name,
   judgeQueueName: judgeQueue.name,
+  judgeDeadLetterQueueName: judgeDeadLetterQueue.name,
+  judgeNotifyTopicArn: judgeNotifyTopic.arn,
+  eventSocket: pulumi.interpolate`${socketApi.apiEndpoint}/${socketStage.name}`,
   storageBucketDomain: storageBucket.bucketDomainName
 };
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"sync"
+)
+
+// subscriptionBuffer is the number of events kept for a slow subscriber.
+// Events beyond it are dropped for the subscriber.
+const subscriptionBuffer = 16
+
+type subscription struct {
+	events chan Event
+	filter func(Event) bool
+}
+
+// Broker delivers the events to the subscribers in the same process
+type Broker struct {
+	mutex         sync.Mutex
+	subscriptions map[*subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscriptions: map[*subscription]struct{}{},
+	}
+}
+
+func (broker *Broker) Publish(event Event) error {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for sub := range broker.subscriptions {
+		if !sub.filter(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns the channel of the events accepted by filter.
+// The subscription lasts until cancel is called.
+func (broker *Broker) Subscribe(filter func(Event) bool) (events <-chan Event, cancel func()) {
+	sub := &subscription{
+		events: make(chan Event, subscriptionBuffer),
+		filter: filter,
+	}
+
+	broker.mutex.Lock()
+	broker.subscriptions[sub] = struct{}{}
+	broker.mutex.Unlock()
+
+	return sub.events, func() {
+		broker.mutex.Lock()
+		delete(broker.subscriptions, sub)
+		broker.mutex.Unlock()
+	}
+}
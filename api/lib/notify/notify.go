@@ -0,0 +1,90 @@
+// Package notify publishes the status transitions of the submissions,
+// so that clients can be pushed the progress of the judge instead of polling.
+package notify
+
+import (
+	"log"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+// Event is the status of a submission after a transition
+type Event struct {
+	SubmissionID string        `json:"submission_id"`
+	ProblemID    string        `json:"problem_id"`
+	UserID       string        `json:"user_id"`
+	Result       model.Result  `json:"result"`
+	Phases       []model.Phase `json:"phases"`
+}
+
+func NewEvent(submission model.Submission) Event {
+	return Event{
+		SubmissionID: submission.ID,
+		ProblemID:    submission.ProblemID,
+		UserID:       submission.UserID,
+		Result:       submission.Status(),
+		Phases:       submission.Phases,
+	}
+}
+
+type Publisher interface {
+	Publish(event Event) error
+}
+
+// SubmissionTable is the submission table which publishes an event every time a submission
+// is put, or the result or the phases of a submission are updated through it
+type SubmissionTable struct {
+	storage.Table
+	publisher Publisher
+}
+
+func NewSubmissionTable(table storage.Table, publisher Publisher) SubmissionTable {
+	return SubmissionTable{
+		Table:     table,
+		publisher: publisher,
+	}
+}
+
+// Put puts the item, and publishes its status if it is a submission (like a rejudged one).
+// Failing to publish does not fail the put.
+func (table SubmissionTable) Put(item interface{}) error {
+	if err := table.Table.Put(item); err != nil {
+		return err
+	}
+
+	submission, ok := item.(model.Submission)
+	if !ok {
+		return nil
+	}
+
+	if err := table.publisher.Publish(NewEvent(submission)); err != nil {
+		log.Printf("failed to publish %v: %v", submission.ID, err)
+	}
+
+	return nil
+}
+
+// Update updates the item, then reads it back and publishes its status.
+// Failing to publish does not fail the update.
+func (table SubmissionTable) Update(name string, value interface{}, attribute string, newValue interface{}) error {
+	if err := table.Table.Update(name, value, attribute, newValue); err != nil {
+		return err
+	}
+
+	if attribute != "result" && attribute != "phases" {
+		return nil
+	}
+
+	var submission model.Submission
+	if err := table.Table.Get(name, value, &submission); err != nil {
+		log.Printf("failed to read %v for the notification: %v", value, err)
+		return nil
+	}
+
+	if err := table.publisher.Publish(NewEvent(submission)); err != nil {
+		log.Printf("failed to publish %v: %v", value, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// SNSPublisher publishes the events as JSON messages to an SNS topic,
+// with the submission and the user as message attributes for subscription filters
+type SNSPublisher struct {
+	topicArn string
+	snsc     *sns.SNS
+}
+
+func NewSNSPublisher(topicArn string, snsc *sns.SNS) SNSPublisher {
+	return SNSPublisher{
+		topicArn: topicArn,
+		snsc:     snsc,
+	}
+}
+
+func (publisher SNSPublisher) Publish(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = publisher.snsc.Publish(&sns.PublishInput{
+		TopicArn: aws.String(publisher.topicArn),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"submission_id": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(event.SubmissionID),
+			},
+			"user_id": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(event.UserID),
+			},
+		},
+	})
+
+	return err
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+)
+
+// ErrGone is returned by Send for a connection which is already closed
+var ErrGone = errors.New("connection gone")
+
+// Sender sends messages to the WebSocket connections
+type Sender interface {
+	Send(connectionID string, message []byte) error
+}
+
+// APIGatewaySender sends the messages through the management API of an API Gateway WebSocket API
+type APIGatewaySender struct {
+	client *apigatewaymanagementapi.ApiGatewayManagementApi
+}
+
+func NewAPIGatewaySender(client *apigatewaymanagementapi.ApiGatewayManagementApi) APIGatewaySender {
+	return APIGatewaySender{
+		client: client,
+	}
+}
+
+func (sender APIGatewaySender) Send(connectionID string, message []byte) error {
+	_, err := sender.client.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(connectionID),
+		Data:         message,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == apigatewaymanagementapi.ErrCodeGoneException {
+		return ErrGone
+	}
+
+	return err
+}
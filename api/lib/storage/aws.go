@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/guregu/dynamo"
+)
+
+type S3BlobStore struct {
+	bucketName string
+	s3c        *s3.S3
+}
+
+func NewS3BlobStore(bucketName string, s3c *s3.S3) S3BlobStore {
+	return S3BlobStore{
+		bucketName: bucketName,
+		s3c:        s3c,
+	}
+}
+
+func (store S3BlobStore) Get(key string) (io.ReadCloser, error) {
+	out, err := store.s3c.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(store.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+func (store S3BlobStore) Put(key string, body io.ReadSeeker, cacheControl string) error {
+	input := &s3.PutObjectInput{
+		Bucket:       aws.String(store.bucketName),
+		Key:          aws.String(key),
+		Body:         aws.ReadSeekCloser(body),
+		CacheControl: aws.String(cacheControl),
+	}
+	if contentType := ContentType(key); contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	_, err := store.s3c.PutObject(input)
+
+	return err
+}
+
+func (store S3BlobStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := store.s3c.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(store.bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (store S3BlobStore) Delete(key string) error {
+	_, err := store.s3c.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(store.bucketName),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
+
+type SQSQueue struct {
+	queueURL string
+	sqsc     *sqs.SQS
+}
+
+func NewSQSQueue(queueName string, sqsc *sqs.SQS) (SQSQueue, error) {
+	out, err := sqsc.GetQueueUrl(&sqs.GetQueueUrlInput{
+		QueueName: aws.String(queueName),
+	})
+	if err != nil {
+		return SQSQueue{}, err
+	}
+
+	return SQSQueue{
+		queueURL: *out.QueueUrl,
+		sqsc:     sqsc,
+	}, nil
+}
+
+func (queue SQSQueue) Push(body string) error {
+	_, err := queue.sqsc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(queue.queueURL),
+		MessageBody: aws.String(body),
+	})
+
+	return err
+}
+
+// Receive receives at most max messages (up to 10, the limit of SQS).
+// wait is rounded down to seconds (up to 20).
+func (queue SQSQueue) Receive(max int, wait time.Duration, visibility time.Duration) ([]Message, error) {
+	if max > 10 {
+		max = 10
+	}
+
+	out, err := queue.sqsc.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(queue.queueURL),
+		MaxNumberOfMessages: aws.Int64(int64(max)),
+		WaitTimeSeconds:     aws.Int64(int64(wait / time.Second)),
+		VisibilityTimeout:   aws.Int64(int64(visibility / time.Second)),
+		AttributeNames:      []*string{aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+	for _, m := range out.Messages {
+		message := Message{
+			Body:          *m.Body,
+			ReceiptHandle: *m.ReceiptHandle,
+		}
+		if count, ok := m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]; ok {
+			message.ReceiveCount, _ = strconv.ParseInt(*count, 10, 64)
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+func (queue SQSQueue) Delete(receiptHandle string) error {
+	_, err := queue.sqsc.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queue.queueURL),
+		ReceiptHandle: aws.String(receiptHandle),
+	})
+
+	return err
+}
+
+func (queue SQSQueue) ChangeVisibility(receiptHandle string, visibility time.Duration) error {
+	_, err := queue.sqsc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queue.queueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: aws.Int64(int64(visibility / time.Second)),
+	})
+
+	return err
+}
+
+type DynamoTable struct {
+	table dynamo.Table
+}
+
+func NewDynamoTable(table dynamo.Table) DynamoTable {
+	return DynamoTable{
+		table: table,
+	}
+}
+
+func (table DynamoTable) Put(item interface{}) error {
+	return table.table.Put(item).Run()
+}
+
+func (table DynamoTable) Get(name string, value interface{}, out interface{}) error {
+	err := table.table.Get(name, value).One(out)
+	if err == dynamo.ErrNotFound {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (table DynamoTable) Query(index string, name string, value interface{}, out interface{}) error {
+	err := table.table.Get(name, value).Index(index).All(out)
+	if err == dynamo.ErrNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func (table DynamoTable) Scan(out interface{}) error {
+	return table.table.Scan().All(out)
+}
+
+func (table DynamoTable) Update(name string, value interface{}, attribute string, newValue interface{}) error {
+	return table.table.Update(name, value).Set(attribute, newValue).Run()
+}
+
+func (table DynamoTable) Delete(name string, value interface{}) error {
+	return table.table.Delete(name, value).Run()
+}
@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+)
+
+// DirBlobStore stores objects as files under a directory
+type DirBlobStore struct {
+	dir string
+}
+
+func NewDirBlobStore(dir string) DirBlobStore {
+	return DirBlobStore{
+		dir: dir,
+	}
+}
+
+func (store DirBlobStore) filepath(key string) (string, error) {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", errors.New("invalid key: " + key)
+		}
+	}
+
+	return filepath.Join(store.dir, filepath.FromSlash(key)), nil
+}
+
+func (store DirBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := store.filepath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return file, err
+}
+
+// Put writes the object. The cache control is not stored.
+func (store DirBlobStore) Put(key string, body io.ReadSeeker, cacheControl string) error {
+	path, err := store.filepath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, body)
+	return err
+}
+
+func (store DirBlobStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(store.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(store.dir, path)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (store DirBlobStore) Delete(key string) error {
+	path, err := store.filepath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+type memoryMessage struct {
+	body         string
+	receipt      string
+	receiveCount int64
+	visibleAt    time.Time
+}
+
+// MemoryQueue is a queue in the memory of the process, for running the API and the judge together
+type MemoryQueue struct {
+	mutex    sync.Mutex
+	messages []*memoryMessage
+	receipts int64
+	// pushed is closed and renewed on every push to wake up the receivers
+	pushed chan struct{}
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		pushed: make(chan struct{}),
+	}
+}
+
+func (queue *MemoryQueue) Push(body string) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.messages = append(queue.messages, &memoryMessage{body: body})
+	close(queue.pushed)
+	queue.pushed = make(chan struct{})
+
+	return nil
+}
+
+// pollInterval is how often Receive looks for messages whose visibility timeout passed
+const pollInterval = 100 * time.Millisecond
+
+func (queue *MemoryQueue) Receive(max int, wait time.Duration, visibility time.Duration) ([]Message, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		queue.mutex.Lock()
+
+		var messages []Message
+		now := time.Now()
+		for _, message := range queue.messages {
+			if len(messages) >= max {
+				break
+			}
+			if message.visibleAt.After(now) {
+				continue
+			}
+
+			queue.receipts++
+			message.receipt = strconv.FormatInt(queue.receipts, 10)
+			message.receiveCount++
+			message.visibleAt = now.Add(visibility)
+
+			messages = append(messages, Message{
+				Body:          message.body,
+				ReceiptHandle: message.receipt,
+				ReceiveCount:  message.receiveCount,
+			})
+		}
+
+		pushed := queue.pushed
+		queue.mutex.Unlock()
+
+		if len(messages) > 0 || !now.Before(deadline) {
+			return messages, nil
+		}
+
+		select {
+		case <-pushed:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (queue *MemoryQueue) find(receiptHandle string) int {
+	for index, message := range queue.messages {
+		if message.receipt == receiptHandle {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (queue *MemoryQueue) Delete(receiptHandle string) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if index := queue.find(receiptHandle); index >= 0 {
+		queue.messages = append(queue.messages[:index], queue.messages[index+1:]...)
+	}
+
+	return nil
+}
+
+func (queue *MemoryQueue) ChangeVisibility(receiptHandle string, visibility time.Duration) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	index := queue.find(receiptHandle)
+	if index < 0 {
+		return ErrNotFound
+	}
+
+	queue.messages[index].visibleAt = time.Now().Add(visibility)
+	return nil
+}
+
+// FileTable keeps the items of a table in a JSON file, in the DynamoDB attribute value format.
+// Indexes are not maintained, queries scan all the items.
+type FileTable struct {
+	mutex   sync.Mutex
+	path    string
+	hashKey string
+	items   []map[string]*dynamodb.AttributeValue
+}
+
+// NewFileTable opens the table stored at path, or creates an empty one
+func NewFileTable(path string, hashKey string) (*FileTable, error) {
+	table := &FileTable{
+		path:    path,
+		hashKey: hashKey,
+	}
+
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return table, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, &table.items); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+func (table *FileTable) save() error {
+	body, err := json.Marshal(table.items)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(table.path), 0755); err != nil {
+		return err
+	}
+
+	// Write and rename, so that the file is never left half-written
+	temp := table.path + ".tmp"
+	if err := ioutil.WriteFile(temp, body, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, table.path)
+}
+
+// find returns the indexes of the items whose attribute name equals value
+func (table *FileTable) find(name string, value interface{}) ([]int, error) {
+	av, err := dynamo.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return table.findAttribute(name, av), nil
+}
+
+func (table *FileTable) findAttribute(name string, av *dynamodb.AttributeValue) []int {
+	var indexes []int
+	for index, item := range table.items {
+		if reflect.DeepEqual(item[name], av) {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+func (table *FileTable) Put(item interface{}) error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	encoded, err := dynamo.MarshalItem(item)
+	if err != nil {
+		return err
+	}
+
+	key, ok := encoded[table.hashKey]
+	if !ok {
+		return errors.New("missing hash key: " + table.hashKey)
+	}
+
+	if indexes := table.findAttribute(table.hashKey, key); len(indexes) > 0 {
+		table.items[indexes[0]] = encoded
+	} else {
+		table.items = append(table.items, encoded)
+	}
+
+	return table.save()
+}
+
+func (table *FileTable) Get(name string, value interface{}, out interface{}) error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	indexes, err := table.find(name, value)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		return ErrNotFound
+	}
+
+	return dynamo.UnmarshalItem(table.items[indexes[0]], out)
+}
+
+func (table *FileTable) Query(index string, name string, value interface{}, out interface{}) error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	indexes, err := table.find(name, value)
+	if err != nil {
+		return err
+	}
+
+	return table.unmarshalAll(indexes, out)
+}
+
+func (table *FileTable) Scan(out interface{}) error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	indexes := make([]int, len(table.items))
+	for index := range table.items {
+		indexes[index] = index
+	}
+
+	return table.unmarshalAll(indexes, out)
+}
+
+func (table *FileTable) unmarshalAll(indexes []int, out interface{}) error {
+	slice := reflect.ValueOf(out).Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, len(indexes)))
+
+	for _, index := range indexes {
+		element := reflect.New(slice.Type().Elem())
+		if err := dynamo.UnmarshalItem(table.items[index], element.Interface()); err != nil {
+			return err
+		}
+
+		slice.Set(reflect.Append(slice, element.Elem()))
+	}
+
+	return nil
+}
+
+func (table *FileTable) Update(name string, value interface{}, attribute string, newValue interface{}) error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	key, err := dynamo.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamo.Marshal(newValue)
+	if err != nil {
+		return err
+	}
+
+	// Like DynamoDB, updating a missing item creates it
+	if indexes := table.findAttribute(name, key); len(indexes) > 0 {
+		table.items[indexes[0]][attribute] = av
+	} else if name == table.hashKey {
+		table.items = append(table.items, map[string]*dynamodb.AttributeValue{
+			name:      key,
+			attribute: av,
+		})
+	} else {
+		return ErrNotFound
+	}
+
+	return table.save()
+}
+
+func (table *FileTable) Delete(name string, value interface{}) error {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	indexes, err := table.find(name, value)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	table.items = append(table.items[:indexes[0]], table.items[indexes[0]+1:]...)
+	return table.save()
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewDirBlobStore(dir)
+
+	if _, err := store.Get("missing"); err != ErrNotFound {
+		t.Errorf("get missing: got %v, want %v", err, ErrNotFound)
+	}
+
+	keys, err := store.List("")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("list empty: got %v %v, want no keys", keys, err)
+	}
+
+	for _, key := range []string{"problems/b/content", "problems/a/content", "submissions/x"} {
+		if err := store.Put(key, strings.NewReader(key), "no-cache"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body, err := store.Get("problems/a/content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil || string(content) != "problems/a/content" {
+		t.Errorf("get: got %q %v, want %q", content, err, "problems/a/content")
+	}
+
+	cases := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{
+			name:   "all",
+			prefix: "",
+			want:   []string{"problems/a/content", "problems/b/content", "submissions/x"},
+		},
+		{
+			name:   "prefix",
+			prefix: "problems/",
+			want:   []string{"problems/a/content", "problems/b/content"},
+		},
+		{
+			name:   "no match",
+			prefix: "users/",
+			want:   nil,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := store.List(c.prefix)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+
+	if err := store.Delete("problems/a/content"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("problems/a/content"); err != nil {
+		t.Errorf("delete missing: %v", err)
+	}
+	if _, err := store.Get("problems/a/content"); err != ErrNotFound {
+		t.Errorf("get deleted: got %v, want %v", err, ErrNotFound)
+	}
+
+	for _, key := range []string{"../outside", "problems/../../outside"} {
+		if err := store.Put(key, strings.NewReader(""), ""); err == nil {
+			t.Errorf("%s: got no error", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "outside")); !os.IsNotExist(err) {
+		t.Errorf("put outside of the directory: %v", err)
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	queue := NewMemoryQueue()
+
+	messages, err := queue.Receive(1, 0, time.Minute)
+	if err != nil || len(messages) != 0 {
+		t.Errorf("receive empty: got %+v %v, want no messages", messages, err)
+	}
+
+	if err := queue.Push("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Push("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err = queue.Receive(1, 0, time.Minute)
+	if err != nil || len(messages) != 1 || messages[0].Body != "a" || messages[0].ReceiveCount != 1 {
+		t.Fatalf("receive: got %+v %v, want a", messages, err)
+	}
+	first := messages[0]
+
+	// a is invisible until the visibility timeout passes
+	messages, err = queue.Receive(10, 0, time.Minute)
+	if err != nil || len(messages) != 1 || messages[0].Body != "b" {
+		t.Fatalf("receive invisible: got %+v %v, want b", messages, err)
+	}
+	if err := queue.Delete(messages[0].ReceiptHandle); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := queue.ChangeVisibility(first.ReceiptHandle, 0); err != nil {
+		t.Fatal(err)
+	}
+	messages, err = queue.Receive(10, 0, time.Minute)
+	if err != nil || len(messages) != 1 || messages[0].Body != "a" || messages[0].ReceiveCount != 2 {
+		t.Fatalf("receive again: got %+v %v, want a received twice", messages, err)
+	}
+
+	if err := queue.ChangeVisibility("unknown", 0); err != ErrNotFound {
+		t.Errorf("change visibility of unknown: got %v, want %v", err, ErrNotFound)
+	}
+
+	// a push wakes up a waiting receiver
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		queue.Push("c")
+	}()
+	messages, err = queue.Receive(10, 10*time.Second, time.Minute)
+	if err != nil || len(messages) != 1 || messages[0].Body != "c" {
+		t.Errorf("receive waiting: got %+v %v, want c", messages, err)
+	}
+}
+
+type tableItem struct {
+	ID     string `dynamo:"id"`
+	Owner  string `dynamo:"owner"`
+	Status string `dynamo:"status"`
+}
+
+func TestFileTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tables", "items.json")
+	table, err := NewFileTable(path, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []tableItem{
+		{ID: "1", Owner: "alice", Status: "WJ"},
+		{ID: "2", Owner: "bob", Status: "WJ"},
+		{ID: "3", Owner: "alice", Status: "WJ"},
+		{ID: "1", Owner: "alice", Status: "V"},
+	}
+	for _, item := range items {
+		if err := table.Put(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := table.Put(struct {
+		Owner string `dynamo:"owner"`
+	}{"alice"}); err == nil {
+		t.Errorf("put without the hash key: got no error")
+	}
+
+	if err := table.Update("id", "2", "status", "CE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Update("id", "4", "status", "WJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Update("owner", "carol", "status", "WJ"); err != ErrNotFound {
+		t.Errorf("update by a missing attribute: got %v, want %v", err, ErrNotFound)
+	}
+
+	// Reopen the table, to read the items from the file
+	table, err = NewFileTable(path, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var item tableItem
+	if err := table.Get("id", "1", &item); err != nil {
+		t.Fatal(err)
+	}
+	if want := (tableItem{ID: "1", Owner: "alice", Status: "V"}); item != want {
+		t.Errorf("get: got %+v, want %+v", item, want)
+	}
+	if err := table.Get("id", "5", &item); err != ErrNotFound {
+		t.Errorf("get missing: got %v, want %v", err, ErrNotFound)
+	}
+
+	cases := []struct {
+		name  string
+		query func(out *[]tableItem) error
+		want  []tableItem
+	}{
+		{
+			name: "query",
+			query: func(out *[]tableItem) error {
+				return table.Query("owner-index", "owner", "alice", out)
+			},
+			want: []tableItem{
+				{ID: "1", Owner: "alice", Status: "V"},
+				{ID: "3", Owner: "alice", Status: "WJ"},
+			},
+		},
+		{
+			name: "query no match",
+			query: func(out *[]tableItem) error {
+				return table.Query("owner-index", "owner", "carol", out)
+			},
+			want: []tableItem{},
+		},
+		{
+			name: "scan",
+			query: func(out *[]tableItem) error {
+				return table.Scan(out)
+			},
+			want: []tableItem{
+				{ID: "1", Owner: "alice", Status: "V"},
+				{ID: "2", Owner: "bob", Status: "CE"},
+				{ID: "3", Owner: "alice", Status: "WJ"},
+				{ID: "4", Status: "WJ"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		var got []tableItem
+		if err := c.query(&got); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Package storage abstracts the blob store, the job queue and the tables of the platform,
+// so that the functions and the judge can run on AWS (S3, SQS, DynamoDB) as well as
+// locally (a directory, an in-process queue and JSON files).
+package storage
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"time"
+)
+
+// ErrNotFound is returned when the object or the item does not exist
+var ErrNotFound = errors.New("not found")
+
+// BlobStore stores objects by keys separated by "/", like an S3 bucket
+type BlobStore interface {
+	Get(key string) (io.ReadCloser, error)
+	Put(key string, body io.ReadSeeker, cacheControl string) error
+	// List returns the keys starting with prefix, in lexicographic order
+	List(prefix string) ([]string, error)
+	Delete(key string) error
+}
+
+// ContentType guesses the type of the object from its key, so that the logs are served as text
+func ContentType(key string) string {
+	if path.Ext(key) == ".log" {
+		return "text/plain; charset=utf-8"
+	}
+
+	return mime.TypeByExtension(path.Ext(key))
+}
+
+type Message struct {
+	Body          string
+	ReceiptHandle string
+	ReceiveCount  int64
+}
+
+// Queue is a message queue with at-least-once delivery like SQS.
+// Received messages are invisible to other receivers until the visibility timeout
+// passes, and are delivered again unless they are deleted by then.
+type Queue interface {
+	Push(body string) error
+	// Receive waits up to wait for at most max messages
+	Receive(max int, wait time.Duration, visibility time.Duration) ([]Message, error)
+	Delete(receiptHandle string) error
+	ChangeVisibility(receiptHandle string, visibility time.Duration) error
+}
+
+// Table stores items encoded with the `dynamo` struct tags, like a DynamoDB table.
+// Items are identified by the value of their hash key attribute.
+type Table interface {
+	Put(item interface{}) error
+	// Get reads the item whose attribute name equals value into out
+	Get(name string, value interface{}, out interface{}) error
+	// Query reads the items whose attribute name equals value into out (a pointer to a slice),
+	// using the index of the attribute
+	Query(index string, name string, value interface{}, out interface{}) error
+	// Scan reads all the items into out (a pointer to a slice)
+	Scan(out interface{}) error
+	// Update sets the attribute of the item whose attribute name equals value
+	Update(name string, value interface{}, attribute string, newValue interface{}) error
+	// Delete deletes the item whose hash key attribute name equals value, if it exists
+	Delete(name string, value interface{}) error
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	submithandler "github.com/myuon/provenian/api/functions/submit/handler"
+	"github.com/myuon/provenian/api/lib/notify"
+)
+
+// streamSubmission streams the status of a submission, starting with the current one.
+// The submissions are public, so is the stream.
+func streamSubmission(broker *notify.Broker, submitRepo submithandler.SubmitRepo) func(w http.ResponseWriter, r *http.Request, parameters map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, parameters map[string]string) {
+		submissionID := parameters["submissionId"]
+
+		// Subscribe before reading the current status not to miss a transition in between
+		events, cancel := broker.Subscribe(func(event notify.Event) bool {
+			return event.SubmissionID == submissionID
+		})
+		defer cancel()
+
+		submission, err := submitRepo.Get(submissionID)
+		if err != nil {
+			log.Printf("GET %s: %v", r.URL.Path, err)
+			http.NotFound(w, r)
+			return
+		}
+
+		notify.ServeSSE(w, r, []notify.Event{notify.NewEvent(submission)}, events)
+	}
+}
+
+// streamUser streams the status transitions of the submissions of a user, only to the user
+// as the events function does. The requests are made by user, as the other authorized ones.
+func streamUser(broker *notify.Broker, user string) func(w http.ResponseWriter, r *http.Request, parameters map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, parameters map[string]string) {
+		userID := parameters["userId"]
+		if userID != user {
+			http.Error(w, `{"message":"User is not authorized to access this resource"}`, http.StatusForbidden)
+			return
+		}
+
+		events, cancel := broker.Subscribe(func(event notify.Event) bool {
+			return event.UserID == userID
+		})
+		defer cancel()
+
+		notify.ServeSSE(w, r, nil, events)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+type lambdaHandler func(event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// route is a method of API Gateway, resource is like "/problems/{problemId}/edit"
+type route struct {
+	method   string
+	resource string
+	// The authorizer is called for the route
+	authorized bool
+	// The route is allowed only for the writers (see getWriterResource of the authorizer)
+	writerOnly bool
+	handler    lambdaHandler
+	// stream serves the route directly instead of the Lambda handler,
+	// for the streams API Gateway does not proxy
+	stream func(w http.ResponseWriter, r *http.Request, parameters map[string]string)
+}
+
+// matchResource returns the path parameters if the path matches the resource
+func matchResource(resource string, urlPath string) (map[string]string, bool) {
+	resourceParts := strings.Split(strings.Trim(resource, "/"), "/")
+	pathParts := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if len(resourceParts) != len(pathParts) {
+		return nil, false
+	}
+
+	parameters := map[string]string{}
+	for i, part := range resourceParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return nil, false
+			}
+
+			parameters[strings.Trim(part, "{}")] = pathParts[i]
+		} else if part != pathParts[i] {
+			return nil, false
+		}
+	}
+
+	return parameters, true
+}
+
+// gateway emulates API Gateway with the Lambda proxy integration.
+// Instead of verifying tokens, every authorized request is made by user.
+type gateway struct {
+	routes []route
+	user   string
+	writer bool
+}
+
+func (gw gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// CORS preflight, answered by the OPTIONS methods of createCORSResource in production
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	for _, route := range gw.routes {
+		if route.method != r.Method {
+			continue
+		}
+
+		parameters, ok := matchResource(route.resource, r.URL.Path)
+		if !ok {
+			continue
+		}
+
+		if route.writerOnly && !gw.writer {
+			http.Error(w, `{"message":"User is not authorized to access this resource"}`, http.StatusForbidden)
+			return
+		}
+
+		if route.stream != nil {
+			route.stream(w, r, parameters)
+			return
+		}
+
+		body := new(bytes.Buffer)
+		body.ReadFrom(r.Body)
+
+		event := events.APIGatewayProxyRequest{
+			Resource:              route.resource,
+			Path:                  r.URL.Path,
+			HTTPMethod:            r.Method,
+			Headers:               map[string]string{},
+			QueryStringParameters: map[string]string{},
+			PathParameters:        parameters,
+			Body:                  body.String(),
+		}
+		for key := range r.Header {
+			event.Headers[key] = r.Header.Get(key)
+		}
+		for key := range r.URL.Query() {
+			event.QueryStringParameters[key] = r.URL.Query().Get(key)
+		}
+		if route.authorized {
+			event.RequestContext.Authorizer = map[string]interface{}{
+				"sub": gw.user,
+			}
+			if gw.writer {
+				event.RequestContext.Authorizer["writer"] = true
+			}
+		}
+
+		response, err := invoke(route.handler, event)
+		if err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, `{"message":"Internal server error"}`, http.StatusBadGateway)
+			return
+		}
+
+		for key, value := range response.Headers {
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(response.StatusCode)
+		io.WriteString(w, response.Body)
+		return
+	}
+
+	http.Error(w, `{"message":"Missing Authentication Token"}`, http.StatusForbidden)
+}
+
+// invoke calls the handler, turning a panic into an error as the Lambda runtime does
+func invoke(handler lambdaHandler, event events.APIGatewayProxyRequest) (response events.APIGatewayProxyResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(event)
+}
+
+// blobServer serves the objects of the blob store for GET, like the public storage bucket
+type blobServer struct {
+	blobs storage.BlobStore
+}
+
+func (server blobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := server.blobs.Get(strings.TrimPrefix(r.URL.Path, "/"))
+	if err == storage.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("GET %s: %v", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	if contentType := storage.ContentType(r.URL.Path); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	io.Copy(w, body)
+}
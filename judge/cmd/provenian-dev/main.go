@@ -0,0 +1,105 @@
+// Command provenian-dev runs the whole API on a single HTTP server with local storage,
+// optionally together with the judge, for developing without AWS.
+//
+// The blob store is served under /storage/ (REACT_APP_FILE_STORAGE) and the API
+// under / (REACT_APP_API_ENDPOINT). Tokens are not verified: every request is made by
+// the user given by -user.
+//
+// In addition to the API, the status transitions of the submissions are streamed as
+// Server-Sent Events from /submissions/{submissionId}/events and /users/{userId}/events.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	problemhandler "github.com/myuon/provenian/api/functions/problem/handler"
+	submithandler "github.com/myuon/provenian/api/functions/submit/handler"
+	"github.com/myuon/provenian/api/lib/notify"
+	"github.com/myuon/provenian/api/lib/storage"
+	"github.com/myuon/provenian/judge/worker"
+)
+
+var addr = flag.String("addr", "localhost:8080", "address to listen on")
+var dataDir = flag.String("data", "provenian-data", "directory to store the problems and the submissions")
+var user = flag.String("user", "dev-user", "user ID of the requests")
+var writer = flag.Bool("writer", true, "whether the user has the writer role")
+var runJudge = flag.Bool("judge", false, "run the judge in the same process")
+var judgeWorkers = flag.Int("workers", 1, "the number of submissions the judge verifies in parallel")
+
+func main() {
+	worker.InitSandbox()
+	flag.Parse()
+
+	blobs := storage.NewDirBlobStore(filepath.Join(*dataDir, "storage"))
+	submitTable, err := storage.NewFileTable(filepath.Join(*dataDir, "submit.json"), "id")
+	if err != nil {
+		log.Fatal(err)
+	}
+	problemTable, err := storage.NewFileTable(filepath.Join(*dataDir, "problem.json"), "id")
+	if err != nil {
+		log.Fatal(err)
+	}
+	problemDraftTable, err := storage.NewFileTable(filepath.Join(*dataDir, "problem-draft.json"), "id")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Submissions waiting for the judge are lost on restart
+	queue := storage.NewMemoryQueue()
+
+	// The judge updates the submissions through the table publishing to the broker
+	broker := notify.NewBroker()
+	judgeTable := notify.NewSubmissionTable(submitTable, broker)
+
+	problemRepo := problemhandler.NewProblemRepo(blobs, problemTable, problemDraftTable)
+	problem := func(event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return problemhandler.Handle(problemRepo, event)
+	}
+
+	submitRepo := submithandler.NewSubmitRepo(submitTable, blobs, "/storage")
+	jobQueue := submithandler.NewJobQueue(queue)
+	submit := func(event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return submithandler.Handle(submitRepo, jobQueue, event)
+	}
+
+	// The methods defined in api/index.ts
+	api := gateway{
+		routes: []route{
+			{method: "POST", resource: "/problems", authorized: true, writerOnly: true, handler: problem},
+			{method: "PUT", resource: "/problems/{problemId}/edit", authorized: true, writerOnly: true, handler: problem},
+			{method: "PUT", resource: "/problems/{problemId}/publish", authorized: true, writerOnly: true, handler: problem},
+			{method: "GET", resource: "/problems/drafts", authorized: true, writerOnly: true, handler: problem},
+			{method: "POST", resource: "/problems/{problemId}/submit", authorized: true, handler: submit},
+			{method: "GET", resource: "/problems/{problemId}/submissions", handler: submit},
+			{method: "GET", resource: "/submissions/{submissionId}", handler: submit},
+			{method: "GET", resource: "/submissions/{submissionId}/log", handler: submit},
+			{method: "POST", resource: "/problems/{problemId}/rejudge", authorized: true, writerOnly: true, handler: submit},
+			{method: "POST", resource: "/submissions/{submissionId}/rejudge", authorized: true, writerOnly: true, handler: submit},
+			{method: "POST", resource: "/submissions/rejudge", authorized: true, writerOnly: true, handler: submit},
+			{method: "GET", resource: "/submissions/{submissionId}/events", stream: streamSubmission(broker, submitRepo)},
+			{method: "GET", resource: "/users/{userId}/events", authorized: true, stream: streamUser(broker, *user)},
+		},
+		user:   *user,
+		writer: *writer,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/storage/", http.StripPrefix("/storage", blobServer{blobs: blobs}))
+	mux.Handle("/", api)
+
+	log.Printf("listening on %s", *addr)
+	if !*runJudge {
+		log.Fatal(http.ListenAndServe(*addr, mux))
+	}
+
+	go func() {
+		log.Fatal(http.ListenAndServe(*addr, mux))
+	}()
+
+	worker.Start(queue, nil, blobs, judgeTable, *judgeWorkers)
+}
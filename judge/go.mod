@@ -3,6 +3,7 @@ module github.com/myuon/provenian/judge
 go 1.12
 
 require (
+	github.com/aws/aws-lambda-go v1.12.1
 	github.com/aws/aws-sdk-go v1.23.13
 	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/guregu/dynamo v1.3.1
@@ -12,3 +13,5 @@ require (
 	golang.org/x/net v0.0.0-20190827160401-ba9fcec4b297 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 )
+
+replace github.com/myuon/provenian/api => ../api
@@ -17,6 +17,8 @@ const main = async () => {
     instanceImageId: string;
     submission_table_name: string;
     judge_queue_name: string;
+    dead_letter_queue_name: string;
+    notify_topic_arn: string;
     subnetId: string;
     vpcId: string;
     bucket_name: string;
@@ -68,7 +70,7 @@ const main = async () => {
             },
             {
               effect: "Allow",
-              actions: ["sqs:*", "dynamodb:*", "s3:*"],
+              actions: ["sqs:*", "dynamodb:*", "s3:*", "sns:Publish"],
               resources: ["*"]
             }
           ]
@@ -141,6 +143,14 @@ const main = async () => {
         Essential: true,
         Image: parameters.dockerImage,
         Memory: 1500,
+        // required to create the namespaces and the mounts of the verifier sandbox
+        LinuxParameters: {
+          Capabilities: {
+            Add: ["SYS_ADMIN"]
+          }
+        },
+        // give the judge time to drain (SHUTDOWN_TIMEOUT) before it is killed
+        StopTimeout: 120,
         Environment: [
           {
             Name: "SUBMISSION_TABLE_NAME",
@@ -153,6 +163,14 @@ const main = async () => {
           {
             Name: "BUCKET_NAME",
             Value: parameters.bucket_name
+          },
+          {
+            Name: "DEAD_LETTER_QUEUE_NAME",
+            Value: parameters.dead_letter_queue_name
+          },
+          {
+            Name: "NOTIFY_TOPIC_ARN",
+            Value: parameters.notify_topic_arn
           }
         ],
         LogConfiguration: {
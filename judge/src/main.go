@@ -1,256 +1,79 @@
 package main
 
 import (
-	"bufio"
-	"io"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"path"
-	"time"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/guregu/dynamo"
 
-	"github.com/myuon/provenian/api/functions/submit/model"
+	submithandler "github.com/myuon/provenian/api/functions/submit/handler"
+	"github.com/myuon/provenian/api/lib/notify"
+	"github.com/myuon/provenian/api/lib/storage"
+	"github.com/myuon/provenian/judge/worker"
 )
 
 var submissionTableName = os.Getenv("SUBMISSION_TABLE_NAME")
 var judgeQueueName = os.Getenv("JUDGE_QUEUE_NAME")
-var submissionFilePath = os.Getenv("SUBMISSION_FILE_PATH")
-var isabellePath = os.Getenv("ISABELLE_PATH")
 var bucketName = os.Getenv("BUCKET_NAME")
+var deadLetterQueueName = os.Getenv("DEAD_LETTER_QUEUE_NAME")
 
-type SQSClient struct {
-	queueUrl string
-	*sqs.SQS
-}
-
-func NewSQSClient(queueName string, instance *sqs.SQS) (SQSClient, error) {
-	res, err := instance.GetQueueUrl(&sqs.GetQueueUrlInput{
-		QueueName: aws.String(queueName),
-	})
-	if err != nil {
-		return SQSClient{}, err
-	}
-
-	return SQSClient{
-		queueUrl: *res.QueueUrl,
-		SQS:      instance,
-	}, nil
-}
-
-func (sqsc SQSClient) Receive() ([]sqs.Message, error) {
-	res, err := sqsc.ReceiveMessage(&sqs.ReceiveMessageInput{
-		QueueUrl: aws.String(sqsc.queueUrl),
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	var messages []sqs.Message
-	for _, m := range res.Messages {
-		messages = append(messages, *m)
-	}
-
-	return messages, nil
-}
-
-func (sqsc SQSClient) Delete(receiptHandle string) error {
-	_, err := sqsc.DeleteMessage(&sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(sqsc.queueUrl),
-		ReceiptHandle: aws.String(receiptHandle),
-	})
-
-	return err
-}
-
-type S3Client struct {
-	bucketName string
-	*s3.S3
-}
+// Status transitions of the submissions are published to this SNS topic if given
+var notifyTopicArn = os.Getenv("NOTIFY_TOPIC_ARN")
 
-func NewS3Client(bucketName string, instance *s3.S3) S3Client {
-	return S3Client{
-		bucketName: bucketName,
-		S3:         instance,
-	}
-}
+// The number of submissions verified in parallel
+var judgeWorkers = getenvInt("JUDGE_WORKERS", 1)
 
-func (s3c S3Client) ListObjects(prefix string) ([]*s3.Object, error) {
-	out, err := s3c.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket: aws.String(s3c.bucketName),
-		Prefix: aws.String(prefix),
-	})
+func getenvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
 	if err != nil {
-		return nil, err
+		return fallback
 	}
 
-	return out.Contents, nil
+	return value
 }
 
-func (s3c S3Client) ReadObject(key string) (io.ReadCloser, error) {
-	out, err := s3c.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(s3c.bucketName),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return out.Body, nil
-}
-
-func (s3c S3Client) DownloadObject(key string, filepath string) error {
-	body, err := s3c.ReadObject(key)
-	if err != nil {
-		return err
-	}
-
-	file, err := os.Create(filepath)
-	if err != nil {
-		return err
-	}
+func main() {
+	worker.InitSandbox()
 
-	defer file.Close()
-	if _, err := io.Copy(file, body); err != nil {
-		return err
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(verify(os.Args[2:]))
 	}
 
-	return nil
-}
-
-func main() {
 	config := &aws.Config{Region: aws.String("ap-northeast-1")}
 	if region, ok := os.LookupEnv("AWS_REGION"); ok {
 		config.Region = aws.String(region)
 	}
 
 	sess := session.Must(session.NewSession(config))
-	sqsc, err := NewSQSClient(judgeQueueName, sqs.New(sess))
+	queue, err := storage.NewSQSQueue(judgeQueueName, sqs.New(sess))
 	if err != nil {
 		panic(err)
 	}
 
-	s3c := NewS3Client(bucketName, s3.New(sess))
-	submissionTable := dynamo.New(sess).Table(submissionTableName)
-
-	start(sqsc, s3c, submissionTable)
-}
-
-func start(sqsc SQSClient, s3c S3Client, submissionTable dynamo.Table) {
-	for {
-		ids, err := sqsc.Receive()
+	var deadLetter storage.Queue
+	if deadLetterQueueName != "" {
+		deadLetter, err = storage.NewSQSQueue(deadLetterQueueName, sqs.New(sess))
 		if err != nil {
 			panic(err)
 		}
-
-		for _, message := range ids {
-			submissionID := *message.Body
-
-			if err := execRunner(submissionTable, s3c, submissionID); err != nil {
-				panic(err)
-			}
-
-			if err := sqsc.Delete(*message.ReceiptHandle); err != nil {
-				panic(err)
-			}
-		}
-
-		time.Sleep(15 * time.Second)
-	}
-}
-
-func execRunner(submissionTable dynamo.Table, s3c S3Client, submissionID string) error {
-	var submission model.Submission
-	if err := submissionTable.Get("id", submissionID).One(&submission); err != nil {
-		return err
-	}
-
-	// Download asset files
-	objects, err := s3c.ListObjects(submission.ProblemID + "/" + submission.Language + "/")
-	if err != nil {
-		return err
-	}
-
-	for _, object := range objects {
-		s3c.DownloadObject(*object.Key, path.Join(path.Dir(submissionFilePath), path.Base(*object.Key)))
-	}
-
-	// Save submission file
-	if err := s3c.DownloadObject(submission.Code, submissionFilePath); err != nil {
-		return err
-	}
-
-	// Run verification process
-	var result model.Result
-
-	if submission.Language == "isabelle" {
-		r, err := execIsabelle()
-		if err != nil {
-			return err
-		}
-
-		result = r
-	} else {
-		result = model.CE("Unsupported language: " + submission.Language)
 	}
 
-	if err := submissionTable.Update("id", submission.ID).Set("result", result).Run(); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func execIsabelle() (model.Result, error) {
-	cmd := exec.Command(isabellePath, "build", "-D", path.Dir(submissionFilePath))
-
-	logfilePath := "./out.log"
-	logfile, err := os.Create(logfilePath)
-	if err != nil {
-		return model.Result{}, err
-	}
-	defer logfile.Close()
-
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return model.Result{}, err
-	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return model.Result{}, err
-	}
-
-	writer := bufio.NewWriter(logfile)
-
-	if cmd.Start(); err != nil {
-		return model.Result{}, err
-	}
-
-	go io.Copy(writer, stdoutPipe)
-	go io.Copy(writer, stderrPipe)
-	cmd.Wait()
-	writer.Flush()
-
-	bytes, err := ioutil.ReadFile(logfilePath)
-	if err != nil {
-		return model.Result{}, err
-	}
+	blobs := storage.NewS3BlobStore(bucketName, s3.New(sess))
+	var submissionTable storage.Table = storage.NewDynamoTable(dynamo.New(sess).Table(submissionTableName))
 
-	var result model.Result
-	if cmd.ProcessState.ExitCode() == 0 {
-		result = model.V(string(bytes))
-	} else {
-		result = model.CE(string(bytes))
+	// The rejudged submissions are published as the ones the workers update
+	if notifyTopicArn != "" {
+		submissionTable = notify.NewSubmissionTable(submissionTable, notify.NewSNSPublisher(notifyTopicArn, sns.New(sess)))
 	}
 
-	if err := os.Remove(logfilePath); err != nil {
-		return model.Result{}, err
+	if len(os.Args) > 1 && os.Args[1] == "rejudge" {
+		os.Exit(rejudge(submithandler.NewSubmitRepo(submissionTable, blobs, ""), submithandler.NewJobQueue(queue), os.Args[2:]))
 	}
 
-	return result, err
+	worker.Start(queue, deadLetter, blobs, submissionTable, judgeWorkers)
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	submithandler "github.com/myuon/provenian/api/functions/submit/handler"
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+// rejudge queues the finished submissions again, selected by the arguments:
+//
+//	judge rejudge -submission ID
+//	judge rejudge -problem ID [-result CODE]
+//	judge rejudge -result CODE
+//
+// It returns the exit code, which is 2 for the invalid arguments.
+func rejudge(submitRepo submithandler.SubmitRepo, jobQueue submithandler.JobQueue, args []string) int {
+	flags := flag.NewFlagSet("rejudge", flag.ExitOnError)
+	submissionID := flags.String("submission", "", "the submission to rejudge")
+	problemID := flags.String("problem", "", "rejudge the submissions of the problem")
+	resultCode := flags.String("result", "", "rejudge the submissions having the result code")
+	flags.Parse(args)
+
+	// A submission is rejudged whatever its result is
+	if *submissionID != "" && (*problemID != "" || *resultCode != "") {
+		fmt.Fprintln(os.Stderr, "rejudge: -submission cannot be given with -problem or -result")
+		return 2
+	}
+
+	var submissions []model.Submission
+	var err error
+	if *submissionID != "" {
+		var submission model.Submission
+		submission, err = submitRepo.Get(*submissionID)
+		submissions = []model.Submission{submission}
+	} else if *problemID != "" {
+		var all []model.Submission
+		all, err = submitRepo.ListByProblemID(*problemID)
+		for _, submission := range all {
+			if *resultCode == "" || submission.Result.Code == *resultCode {
+				submissions = append(submissions, submission)
+			}
+		}
+	} else if *resultCode != "" {
+		submissions, err = submitRepo.ListByResultCode(*resultCode)
+	} else {
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	rejudged, err := submithandler.Rejudge(submitRepo, jobQueue, submissions)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, submission := range rejudged {
+		fmt.Println(submission.ID)
+	}
+	fmt.Fprintf(os.Stderr, "rejudged %d of %d submissions\n", len(rejudged), len(submissions))
+	return 0
+}
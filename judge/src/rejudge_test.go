@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	submithandler "github.com/myuon/provenian/api/functions/submit/handler"
+	"github.com/myuon/provenian/api/functions/submit/model"
+	"github.com/myuon/provenian/api/lib/notify"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+func TestRejudge(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		code     int
+		rejudged []string
+	}{
+		{name: "submission", args: []string{"-submission", "s1"}, rejudged: []string{"s1"}},
+		{name: "problem", args: []string{"-problem", "p1"}, rejudged: []string{"s1", "s2"}},
+		{name: "problem and result", args: []string{"-problem", "p1", "-result", "IE"}, rejudged: []string{"s2"}},
+		{name: "result", args: []string{"-result", "IE"}, rejudged: []string{"s2", "s3"}},
+		{name: "submission and result", args: []string{"-submission", "s1", "-result", "IE"}, code: 2},
+		{name: "submission and problem", args: []string{"-submission", "s1", "-problem", "p1"}, code: 2},
+		{name: "nothing", code: 2},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "provenian-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		table, err := storage.NewFileTable(filepath.Join(dir, "submit.json"), "id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, submission := range []model.Submission{
+			{ID: "s1", ProblemID: "p1", Result: model.V("")},
+			{ID: "s2", ProblemID: "p1", Result: model.IE("")},
+			{ID: "s3", ProblemID: "p2", Result: model.IE("")},
+			{ID: "s4", ProblemID: "p1", Phases: []model.Phase{model.NewPhase(model.PhaseQueued)}},
+		} {
+			if err := table.Put(submission); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		broker := notify.NewBroker()
+		events, cancel := broker.Subscribe(func(notify.Event) bool { return true })
+		defer cancel()
+
+		queue := storage.NewMemoryQueue()
+		submitRepo := submithandler.NewSubmitRepo(notify.NewSubmissionTable(table, broker), storage.NewDirBlobStore(dir), "")
+		if code := rejudge(submitRepo, submithandler.NewJobQueue(queue), c.args); code != c.code {
+			t.Errorf("%s: got the exit code %d, want %d", c.name, code, c.code)
+			continue
+		}
+
+		var queued, published []string
+		messages, err := queue.Receive(10, 0, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, message := range messages {
+			queued = append(queued, message.Body)
+		}
+		for len(events) > 0 {
+			published = append(published, (<-events).SubmissionID)
+		}
+		sort.Strings(queued)
+		sort.Strings(published)
+
+		if !reflect.DeepEqual(queued, c.rejudged) {
+			t.Errorf("%s: queued %v, want %v", c.name, queued, c.rejudged)
+		}
+		if !reflect.DeepEqual(published, c.rejudged) {
+			t.Errorf("%s: published %v, want %v", c.name, published, c.rejudged)
+		}
+	}
+}
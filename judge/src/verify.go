@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/functions/submit/model"
+	"github.com/myuon/provenian/api/lib/storage"
+	"github.com/myuon/provenian/judge/worker"
+)
+
+// The languages of the proof files by their extensions
+var verifyLanguages = map[string]string{
+	".thy":  "isabelle",
+	".v":    "coq",
+	".lean": "lean4",
+}
+
+// verify judges a proof against a problem on the local filesystem, and prints the result as JSON:
+//
+//	judge verify [-language LANG] PROBLEM PROOF [FILE...]
+//
+// PROBLEM is the statement JSON of the problem, or the directory having it as problem.json.
+// The attachments are read from the subdirectory of the language next to the statement
+// (e.g. isabelle/ROOT), which the problem needs to support the language.
+// FILEs are submitted with PROOF, as the files of the submission.
+//
+// The submission is verified in a temporary blob store and table, exactly as the workers do.
+// The full log goes to stderr. It returns the exit code, which is 1 unless the proof is verified.
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	language := flags.String("language", "", "the language of the proof (by default, guessed from its extension)")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+
+	statement := flags.Arg(0)
+	if info, err := os.Stat(statement); err == nil && info.IsDir() {
+		statement = filepath.Join(statement, "problem.json")
+	}
+	proof := flags.Arg(1)
+
+	if *language == "" {
+		*language = verifyLanguages[filepath.Ext(proof)]
+		if *language == "" {
+			fmt.Fprintln(os.Stderr, "verify: cannot guess the language of "+proof+", give it by -language")
+			return 2
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "provenian-verify-")
+	if err != nil {
+		return verifyFailed(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blobs := storage.NewDirBlobStore(filepath.Join(dir, "storage"))
+	submissionTable, err := storage.NewFileTable(filepath.Join(dir, "submit.json"), "id")
+	if err != nil {
+		return verifyFailed(err)
+	}
+
+	problem, err := putLocalProblem(blobs, statement, *language)
+	if err != nil {
+		return verifyFailed(err)
+	}
+
+	submission := model.Submission{
+		ID:        "local",
+		ProblemID: problem.ID,
+		Language:  *language,
+		Phases:    []model.Phase{model.NewPhase(model.PhaseQueued)},
+	}
+	submission.Code, err = putLocalFile(blobs, problem.ID+"/submissions/"+submission.ID, proof)
+	if err != nil {
+		return verifyFailed(err)
+	}
+	for _, file := range flags.Args()[2:] {
+		filename := filepath.Base(file)
+		key, err := putLocalFile(blobs, submission.Code+".files/"+filename, file)
+		if err != nil {
+			return verifyFailed(err)
+		}
+
+		submission.Files = append(submission.Files, model.SubmissionFile{Filename: filename, Code: key})
+	}
+
+	if err := submissionTable.Put(submission); err != nil {
+		return verifyFailed(err)
+	}
+
+	if err := worker.Run(submissionTable, blobs, submission.ID); err != nil {
+		fmt.Fprintln(os.Stderr, "verify: the judge failed: "+err.Error())
+		return 2
+	}
+
+	if err := submissionTable.Get("id", submission.ID, &submission); err != nil {
+		return verifyFailed(err)
+	}
+	result := submission.Status()
+
+	if result.LogKey != "" {
+		log, err := blobs.Get(result.LogKey)
+		if err != nil {
+			return verifyFailed(err)
+		}
+		io.Copy(os.Stderr, log)
+		log.Close()
+	}
+
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return verifyFailed(err)
+	}
+	fmt.Println(string(body))
+
+	if result.Code != model.V("").Code {
+		return 1
+	}
+
+	return 0
+}
+
+// verifyFailed reports the error of the input or the environment, which is not the result of the proof
+func verifyFailed(err error) int {
+	fmt.Fprintln(os.Stderr, "verify: "+err.Error())
+	return 2
+}
+
+// putLocalProblem publishes the problem of the statement JSON and its attachments of the language
+func putLocalProblem(blobs storage.BlobStore, statement string, language string) (problemmodel.Problem, error) {
+	body, err := ioutil.ReadFile(statement)
+	if err != nil {
+		return problemmodel.Problem{}, err
+	}
+
+	var problem problemmodel.Problem
+	if err := json.Unmarshal(body, &problem); err != nil {
+		return problemmodel.Problem{}, fmt.Errorf("%s: %v", statement, err)
+	}
+	// Named after the directory of problem.json, or the statement file itself
+	if problem.ID == "" {
+		problem.ID = strings.TrimSuffix(filepath.Base(statement), filepath.Ext(statement))
+		if problem.ID == "problem" {
+			problem.ID = filepath.Base(filepath.Dir(statement))
+		}
+	}
+
+	// The attachments are shared by the versions of the language
+	base := problemmodel.BaseLanguage(language)
+	attachments, err := ioutil.ReadDir(filepath.Join(filepath.Dir(statement), base))
+	if err != nil && !os.IsNotExist(err) {
+		return problemmodel.Problem{}, err
+	}
+
+	var filenames []string
+	for _, attachment := range attachments {
+		if attachment.IsDir() {
+			continue
+		}
+
+		key := problem.ID + "/" + base + "/" + attachment.Name()
+		if _, err := putLocalFile(blobs, key, filepath.Join(filepath.Dir(statement), base, attachment.Name())); err != nil {
+			return problemmodel.Problem{}, err
+		}
+		filenames = append(filenames, attachment.Name())
+	}
+
+	// The files listed in the statement are kept in their order, as the API does
+	if len(problem.Files.Get(base)) == 0 {
+		problem.Files.Set(base, filenames)
+	}
+
+	body, err = json.Marshal(problem)
+	if err != nil {
+		return problemmodel.Problem{}, err
+	}
+	if err := blobs.Put(problem.ID+".json", bytes.NewReader(body), ""); err != nil {
+		return problemmodel.Problem{}, err
+	}
+
+	return problem, nil
+}
+
+// putLocalFile copies the file to the key of the blob store, and returns the key
+func putLocalFile(blobs storage.BlobStore, key string, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := blobs.Put(key, f, ""); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+	"github.com/myuon/provenian/judge/worker"
+)
+
+// fakeVerifier verifies every proof, without running a prover
+type fakeVerifier struct{}
+
+func (fakeVerifier) Prepare(ws worker.Workspace, code io.Reader) error {
+	return nil
+}
+
+func (fakeVerifier) Run(ws worker.Workspace) (worker.Execution, error) {
+	return worker.Execution{}, nil
+}
+
+func (fakeVerifier) Classify(ws worker.Workspace, execution worker.Execution) (model.Result, error) {
+	return model.V(""), nil
+}
+
+// failingVerifier rejects every proof
+type failingVerifier struct {
+	fakeVerifier
+}
+
+func (failingVerifier) Classify(ws worker.Workspace, execution worker.Execution) (model.Result, error) {
+	return model.CE("failed"), nil
+}
+
+func init() {
+	worker.RegisterVerifier("coq8.98", fakeVerifier{})
+	worker.RegisterVerifier("coq8.99", failingVerifier{})
+}
+
+func TestVerifyExitCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"problem/problem.json": `{"versions": {"coq": ["coq8.98", "coq8.99"]}}`,
+		"problem/coq/Defs.v":   "Definition x := 0.",
+		"Proof.v":              "Theorem x_is_0 : x = 0. Proof. reflexivity. Qed.",
+		"Proof.txt":            "",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	problem, proof := filepath.Join(dir, "problem"), filepath.Join(dir, "Proof.v")
+
+	cases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"verified", []string{"-language", "coq8.98", problem, proof}, 0},
+		{"verified by the statement", []string{"-language", "coq8.98", filepath.Join(problem, "problem.json"), proof}, 0},
+		{"not verified", []string{"-language", "coq8.99", problem, proof}, 1},
+		{"unsupported language", []string{"-language", "coq8.97", problem, proof}, 1},
+		{"no proof", []string{problem}, 2},
+		{"unknown extension", []string{problem, filepath.Join(dir, "Proof.txt")}, 2},
+		{"missing problem", []string{"-language", "coq8.98", filepath.Join(dir, "missing"), proof}, 2},
+		{"missing proof", []string{"-language", "coq8.98", problem, filepath.Join(dir, "Missing.v")}, 2},
+	}
+
+	stdout := os.Stdout
+	defer func() { os.Stdout = stdout }()
+
+	for _, c := range cases {
+		// The results printed are not checked
+		output, err := ioutil.TempFile(dir, "stdout-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = output
+
+		code := verify(c.args)
+		os.Stdout = stdout
+		output.Close()
+
+		if code != c.code {
+			body, _ := ioutil.ReadFile(output.Name())
+			t.Errorf("%s: got the exit code %d, want %d\n%s", c.name, code, c.code, bytes.TrimSpace(body))
+		}
+	}
+}
@@ -0,0 +1,611 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+var coqcPath = os.Getenv("COQC_PATH")
+
+const coqSubmissionFile = "Submitted.v"
+
+// The file checking the goals, compiled after the submission
+const coqCheckFile = "ProvenianCheck.v"
+
+// constr_eq compares the types syntactically (up to alpha-conversion),
+// so that a theorem merely convertible to the goal is rejected
+const coqCheckGoal = `Goal True.
+  let t := type of %s in constr_eq t (%s).
+  exact I.
+Qed.
+`
+
+// The check prints the assumptions of the i-th audited theorem into ProvenianAssumptions<i>.out
+const coqAssumptionsFile = "ProvenianAssumptions"
+
+// Print Assumptions prints the shortest names in its context, which the submission can make
+// look like the names of other modules. This file locates them into the full names,
+// printing the i-th name into ProvenianLocate<i>.out.
+const coqLocateFile = "ProvenianLocate.v"
+
+// The logical path the workspace is bound to, so that the submission can
+// `Require Import` the problem's attachments
+const coqLogicalPath = "Provenian"
+
+type CoqVerifier struct {
+	coqcPath string
+}
+
+func init() {
+	if coqcPath == "" {
+		coqcPath = "coqc"
+	}
+
+	RegisterVerifier("coq", CoqVerifier{coqcPath: coqcPath})
+	for language, path := range versionedPaths("COQC_VERSIONS", "coq") {
+		RegisterVerifier(language, CoqVerifier{coqcPath: path})
+	}
+}
+
+// Prepare rejects the submissions admitting a proof before anything is compiled,
+// and puts the submission into the workspace
+func (verifier CoqVerifier) Prepare(ws Workspace, code io.Reader) error {
+	if err := rejectFiles(ws); err != nil {
+		return err
+	}
+
+	source, err := ioutil.ReadAll(code)
+	if err != nil {
+		return err
+	}
+
+	if lines := findCoqAdmitted(string(source)); len(lines) > 0 {
+		var message strings.Builder
+		var diagnostics []model.Diagnostic
+		for _, line := range lines {
+			fmt.Fprintf(&message, "%s:%d: Admitted\n", coqSubmissionFile, line)
+			diagnostics = append(diagnostics, model.Diagnostic{
+				Theory:   strings.TrimSuffix(coqSubmissionFile, ".v"),
+				Line:     line,
+				Severity: model.SeverityError,
+				Message:  "Cheat: Admitted",
+			})
+		}
+
+		return cheatDetected{message: message.String(), diagnostics: diagnostics}
+	}
+
+	return writeFile(path.Join(ws.Dir, coqSubmissionFile), bytes.NewReader(source))
+}
+
+// coqFiles lists the files compiled before the check: the .v attachments in the order
+// the problem lists them, the ones it does not list, and then the submission
+func coqFiles(ws Workspace) []string {
+	attached := map[string]bool{}
+	for _, filename := range ws.Attachments {
+		attached[filename] = attached[filename] || strings.HasSuffix(filename, ".v")
+	}
+	for _, filename := range []string{coqSubmissionFile, coqCheckFile, coqLocateFile} {
+		delete(attached, filename)
+	}
+
+	var files []string
+	for _, filename := range append(append([]string{}, ws.Problem.Files.Get("coq")...), ws.Attachments...) {
+		if attached[filename] {
+			files = append(files, filename)
+			delete(attached, filename)
+		}
+	}
+
+	return append(files, coqSubmissionFile)
+}
+
+// Run compiles the files of coqFiles, and then the check: the goals against their statements,
+// and the assumptions of the goals or, if the problem has no goals, of all the declarations
+// of the submission. The check is written only after the submission is compiled, so that
+// the submission cannot tamper with it. The logs of all the compilations are concatenated.
+func (verifier CoqVerifier) Run(ws Workspace) (Execution, error) {
+	source, err := ioutil.ReadFile(path.Join(ws.Dir, coqSubmissionFile))
+	if err != nil {
+		return Execution{}, err
+	}
+
+	var log strings.Builder
+	for _, filename := range coqFiles(ws) {
+		execution, err := verifier.compile(ws, filename, &log)
+		if err != nil || execution.ExitCode != 0 {
+			return execution, err
+		}
+	}
+
+	audited := findCoqDeclarations(string(source))
+	if len(ws.Goals) > 0 {
+		audited = nil
+		for _, goal := range ws.Goals {
+			audited = append(audited, goal.Name)
+		}
+	}
+	if len(audited) == 0 {
+		return Execution{
+			ExitCode: 0,
+			Log:      log.String(),
+		}, nil
+	}
+
+	submission := coqLogicalPath + "." + strings.TrimSuffix(coqSubmissionFile, ".v")
+
+	var check strings.Builder
+	fmt.Fprintf(&check, "Require %s.\n\n", submission)
+	for _, goal := range ws.Goals {
+		fmt.Fprintf(&check, coqCheckGoal, submission+"."+goal.Name, goal.Statement)
+	}
+	for index, name := range audited {
+		fmt.Fprintf(&check, "Redirect \"%s%d\" Print Assumptions %s.%s.\n", coqAssumptionsFile, index, submission, name)
+	}
+
+	if err := writeCoqFile(ws, coqCheckFile, check.String(), coqAssumptionsFile, len(audited)); err != nil {
+		return Execution{}, err
+	}
+
+	execution, err := verifier.compile(ws, coqCheckFile, &log)
+	if err != nil {
+		return Execution{}, err
+	}
+	if execution.ExitCode != 0 {
+		// The submission is fine by itself but the goal check failed
+		execution.WrongStatement = len(ws.Goals) > 0
+		return execution, nil
+	}
+
+	execution.Dependencies, err = verifier.assumptions(ws, submission, audited)
+	return execution, err
+}
+
+// compile compiles the file, appending its output to the log
+func (verifier CoqVerifier) compile(ws Workspace, filename string, log *strings.Builder) (Execution, error) {
+	execution, err := runCommand(ws, verifier.coqcPath, "-R", ".", coqLogicalPath, filename)
+	if err != nil {
+		return Execution{}, err
+	}
+
+	log.WriteString(execution.Log)
+	execution.Log = log.String()
+
+	return execution, nil
+}
+
+// writeCoqFile writes the file for the judge, removing whatever the submission left
+// at its path and at the paths of the outputs it redirects to
+func writeCoqFile(ws Workspace, filename string, content string, outputs string, count int) error {
+	paths := []string{path.Join(ws.Dir, filename)}
+	for index := 0; index < count; index++ {
+		paths = append(paths, path.Join(ws.Dir, fmt.Sprintf("%s%d.out", outputs, index)))
+	}
+
+	for _, file := range paths {
+		if err := os.RemoveAll(file); err != nil {
+			return err
+		}
+	}
+
+	return writeFile(path.Join(ws.Dir, filename), strings.NewReader(content))
+}
+
+// assumptions reads the assumptions of the audited theorems printed by the check, and locates them.
+// The axioms of the problem, which are outside the submission, are not reported.
+func (verifier CoqVerifier) assumptions(ws Workspace, submission string, audited []string) ([]model.Dependency, error) {
+	var dependencies []model.Dependency
+	var names []string
+	indices := map[string]int{}
+	for index, theorem := range audited {
+		output, err := ioutil.ReadFile(path.Join(ws.Dir, fmt.Sprintf("%s%d.out", coqAssumptionsFile, index)))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range parseCoqAssumptions(string(output)) {
+			if _, ok := indices[name]; !ok {
+				indices[name] = len(names)
+				names = append(names, name)
+			}
+
+			dependencies = append(dependencies, model.Dependency{Kind: model.DependencyAxiom, Name: name, Theorem: theorem})
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	locateOutputs := strings.TrimSuffix(coqLocateFile, ".v")
+
+	var locate strings.Builder
+	fmt.Fprintf(&locate, "Require %s.\n\n", submission)
+	for index, name := range names {
+		fmt.Fprintf(&locate, "Redirect \"%s%d\" Locate %s.\n", locateOutputs, index, name)
+	}
+
+	if err := writeCoqFile(ws, coqLocateFile, locate.String(), locateOutputs, len(names)); err != nil {
+		return nil, err
+	}
+
+	var log strings.Builder
+	execution, err := verifier.compile(ws, coqLocateFile, &log)
+	if err != nil {
+		return nil, err
+	}
+	if execution.ExitCode != 0 {
+		return nil, fmt.Errorf("failed to locate the assumptions:\n%s", execution.Log)
+	}
+
+	var located []model.Dependency
+	for _, dependency := range dependencies {
+		output, err := ioutil.ReadFile(path.Join(ws.Dir, fmt.Sprintf("%s%d.out", locateOutputs, indices[dependency.Name])))
+		if err != nil {
+			return nil, err
+		}
+
+		fields := strings.Fields(string(output))
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("failed to locate %s: %s", dependency.Name, output)
+		}
+
+		dependency.Name = fields[1]
+		if strings.HasPrefix(dependency.Name, coqLogicalPath+".") && !strings.HasPrefix(dependency.Name, submission+".") {
+			continue
+		}
+
+		located = append(located, dependency)
+	}
+
+	return located, nil
+}
+
+// parseCoqAssumptions lists the names in the output of Print Assumptions. The assumptions
+// are listed under headers like "Axioms:", as "name : type" with the type continued on
+// the indented lines, or only by the names for the unsafe constants.
+func parseCoqAssumptions(output string) []string {
+	var names []string
+
+	listing := false
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		if strings.HasSuffix(line, ":") && !strings.Contains(line, " : ") {
+			listing = true
+			continue
+		}
+		if !listing {
+			continue
+		}
+
+		names = append(names, strings.Fields(strings.SplitN(line, " : ", 2)[0])[0])
+	}
+
+	return names
+}
+
+type coqToken struct {
+	Text string
+	Line int
+}
+
+// coqSentences splits the source into its sentences, which end at a dot followed by a space.
+// The tokens are the identifiers (qualified ones like Coq.Init.Nat.add in one token), ":="
+// and the other symbols one by one. Comments and strings are dropped.
+func coqSentences(source string) [][]coqToken {
+	var sentences [][]coqToken
+	var sentence []coqToken
+
+	line := 1
+	depth := 0
+	for i := 0; i < len(source); {
+		switch {
+		case strings.HasPrefix(source[i:], "(*"):
+			depth++
+			i += 2
+		case depth > 0 && strings.HasPrefix(source[i:], "*)"):
+			depth--
+			i += 2
+		case depth == 0 && source[i] == '"':
+			// Strings end at the next quote, and "" is an escaped quote
+			for i++; i < len(source); i++ {
+				if source[i] == '\n' {
+					line++
+				}
+				if source[i] == '"' {
+					if i+1 < len(source) && source[i+1] == '"' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+		case depth == 0 && isCoqIdentByte(source[i]):
+			start := i
+			for i < len(source) && (isCoqIdentByte(source[i]) || source[i] == '.' && i+1 < len(source) && isCoqIdentByte(source[i+1])) {
+				i++
+			}
+			sentence = append(sentence, coqToken{Text: source[start:i], Line: line})
+		case depth == 0 && source[i] == '.' && (i+1 == len(source) || isCoqSpace(source[i+1])):
+			sentences = append(sentences, sentence)
+			sentence = nil
+			i++
+		case depth == 0 && strings.HasPrefix(source[i:], ":="):
+			sentence = append(sentence, coqToken{Text: ":=", Line: line})
+			i += 2
+		default:
+			if source[i] == '\n' {
+				line++
+			}
+			if depth == 0 && !isCoqSpace(source[i]) {
+				sentence = append(sentence, coqToken{Text: source[i : i+1], Line: line})
+			}
+			i++
+		}
+	}
+	if len(sentence) > 0 {
+		sentences = append(sentences, sentence)
+	}
+
+	return sentences
+}
+
+func isCoqIdentByte(b byte) bool {
+	return b == '_' || b == '\'' || b >= 0x80 || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
+
+func isCoqSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// Commands declaring a constant named by the identifier after them
+var coqDefinitionCommands = map[string]bool{
+	"Theorem":     true,
+	"Lemma":       true,
+	"Fact":        true,
+	"Remark":      true,
+	"Corollary":   true,
+	"Proposition": true,
+	"Property":    true,
+	"Example":     true,
+	"Definition":  true,
+	"Fixpoint":    true,
+	"CoFixpoint":  true,
+	"Instance":    true,
+}
+
+// Commands declaring axioms, by the identifiers before the colon or in each binder.
+// The ones marked false declare local variables inside sections.
+var coqAxiomCommands = map[string]bool{
+	"Axiom":       true,
+	"Axioms":      true,
+	"Parameter":   true,
+	"Parameters":  true,
+	"Conjecture":  true,
+	"Conjectures": true,
+	"Variable":    false,
+	"Variables":   false,
+	"Hypothesis":  false,
+	"Hypotheses":  false,
+}
+
+// Words before the command, like Local Definition or Program Fixpoint
+var coqCommandPrefixes = map[string]bool{
+	"Local":         true,
+	"Global":        true,
+	"Polymorphic":   true,
+	"Monomorphic":   true,
+	"Program":       true,
+	"Cumulative":    true,
+	"NonCumulative": true,
+}
+
+type coqScope struct {
+	// The name of the module or the functor, "" for the sections
+	name    string
+	functor bool
+	// Module types are not audited, as they declare nothing
+	moduleType bool
+}
+
+// findCoqDeclarations lists the constants the source declares, with the modules they are in
+// (like M.foo). The declarations in a functor are listed for the modules applying it.
+func findCoqDeclarations(source string) []string {
+	var names []string
+	var scopes []coqScope
+	functors := map[string][]string{}
+
+	declare := func(name string) {
+		var path []string
+		functor := ""
+		for _, scope := range scopes {
+			switch {
+			case scope.moduleType:
+				return
+			case scope.functor:
+				// The declarations of nested functors are not listed
+				if functor != "" {
+					return
+				}
+				functor, path = scope.name, nil
+			case scope.name != "":
+				path = append(path, scope.name)
+			}
+		}
+
+		name = strings.Join(append(path, name), ".")
+		if functor != "" {
+			functors[functor] = append(functors[functor], name)
+			return
+		}
+
+		names = append(names, name)
+	}
+
+	inSection := func() bool {
+		for _, scope := range scopes {
+			if scope.name == "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, sentence := range coqSentences(source) {
+		words := coqCommand(sentence)
+		if len(words) == 0 {
+			continue
+		}
+
+		command, args := words[0].Text, words[1:]
+		switch {
+		case command == "Module":
+			if len(args) > 0 && (args[0].Text == "Import" || args[0].Text == "Export") {
+				args = args[1:]
+			}
+			if len(args) == 0 {
+				continue
+			}
+
+			defined := -1
+			for index, token := range args {
+				if token.Text == ":=" {
+					defined = index
+					break
+				}
+			}
+
+			switch {
+			case args[0].Text == "Type":
+				if defined < 0 {
+					scopes = append(scopes, coqScope{moduleType: true})
+				}
+			case defined >= 0:
+				// The module applies a functor, or is an alias
+				if args[1].Text != "(" && defined+2 < len(args) {
+					for _, name := range functors[args[defined+1].Text] {
+						declare(args[0].Text + "." + name)
+					}
+				}
+			default:
+				scopes = append(scopes, coqScope{name: args[0].Text, functor: len(args) > 1 && args[1].Text == "("})
+			}
+		case command == "Section":
+			scopes = append(scopes, coqScope{})
+		case command == "End":
+			if len(scopes) > 0 {
+				scopes = scopes[:len(scopes)-1]
+			}
+		case command == "Include":
+			if len(args) > 0 {
+				for _, name := range functors[args[0].Text] {
+					declare(name)
+				}
+			}
+		case coqDefinitionCommands[command]:
+			if len(args) > 0 && isCoqIdentByte(args[0].Text[0]) {
+				declare(args[0].Text)
+			}
+		default:
+			global, ok := coqAxiomCommands[command]
+			if !ok || !global && inSection() {
+				continue
+			}
+
+			for _, name := range coqBinderNames(args) {
+				declare(name)
+			}
+		}
+	}
+
+	return names
+}
+
+// coqCommand drops the bullets, the braces, the attributes and the prefixes before the command
+func coqCommand(sentence []coqToken) []coqToken {
+	for len(sentence) > 0 {
+		switch text := sentence[0].Text; {
+		case text == "-" || text == "+" || text == "*" || text == "{" || text == "}":
+			sentence = sentence[1:]
+		case text == "#" && len(sentence) > 1 && sentence[1].Text == "[":
+			end := 2
+			for end < len(sentence) && sentence[end].Text != "]" {
+				end++
+			}
+			if end < len(sentence) {
+				end++
+			}
+			sentence = sentence[end:]
+		case coqCommandPrefixes[text]:
+			sentence = sentence[1:]
+		default:
+			return sentence
+		}
+	}
+
+	return nil
+}
+
+// coqBinderNames reads the names declared by "a b : T" or "(a b : T) (c : U)"
+func coqBinderNames(args []coqToken) []string {
+	var names []string
+
+	if len(args) > 0 && args[0].Text != "(" {
+		for _, token := range args {
+			if !isCoqIdentByte(token.Text[0]) {
+				break
+			}
+			names = append(names, token.Text)
+		}
+
+		return names
+	}
+
+	depth := 0
+	naming := false
+	for _, token := range args {
+		switch {
+		case token.Text == "(":
+			depth++
+			naming = depth == 1
+		case token.Text == ")":
+			depth--
+		case naming && isCoqIdentByte(token.Text[0]):
+			names = append(names, token.Text)
+		default:
+			naming = false
+		}
+	}
+
+	return names
+}
+
+// findCoqAdmitted lists the lines of the source with Admitted outside comments and strings
+func findCoqAdmitted(source string) []int {
+	var lines []int
+	for _, sentence := range coqSentences(source) {
+		for _, token := range sentence {
+			if token.Text == "Admitted" {
+				lines = append(lines, token.Line)
+			}
+		}
+	}
+
+	return lines
+}
+
+// Classify reports the audited theorems depending on the axioms the problem does not allow as CD,
+// including the ones admitted in a way Prepare does not find
+func (verifier CoqVerifier) Classify(ws Workspace, execution Execution) (model.Result, error) {
+	return auditDependencies(ws, "coq", classifyExecution(execution), execution.Dependencies), nil
+}
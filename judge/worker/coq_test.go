@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+)
+
+func TestFindCoqDeclarations(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "theorems and axioms",
+			source: "Axiom ax : False.\nTheorem foo : False.\nProof. exact ax. Qed.\nDefinition bar := 1.",
+			want:   []string{"ax", "foo", "bar"},
+		},
+		{
+			name:   "comments and strings",
+			source: "(* Lemma hidden : True. (* nested *) *)\nNotation \"'Lemma' x\" := x (at level 0).\nLemma shown : True.\nProof. exact I. Qed.",
+			want:   []string{"shown"},
+		},
+		{
+			name:   "attributes and prefixes",
+			source: "#[local] Instance inst : Inhabited nat := {}.\nProgram Fixpoint f (n : nat) : nat := n.\nLocal Definition g := 0.\nInstance : Inhabited bool := {}.",
+			want:   []string{"inst", "f", "g"},
+		},
+		{
+			name:   "several axioms",
+			source: "Axioms a b : False.\nParameters (c : nat) (d e : bool).\nAxiom u@{i} : Type@{i}.",
+			want:   []string{"a", "b", "c", "d", "e", "u"},
+		},
+		{
+			name:   "sections",
+			source: "Section S.\nVariable n : nat.\nHypothesis H : n = 0.\nAxiom global : False.\nLemma l : n = n.\nProof. reflexivity. Qed.\nEnd S.\nVariable top : False.",
+			want:   []string{"global", "l", "top"},
+		},
+		{
+			name:   "modules",
+			source: "Module M.\nLemma a : True.\nProof.\n  - exact I.\nQed.\nModule N.\nDefinition b := 0.\nEnd N.\nEnd M.\nModule Import P <: T.\nDefinition c := 0.\nEnd P.",
+			want:   []string{"M.a", "M.N.b", "P.c"},
+		},
+		{
+			name:   "module types and functors",
+			source: "Module Type T.\nParameter x : nat.\nAxiom hidden : False.\nEnd T.\nModule F (X : T).\nAxiom ax : False.\nLemma l : False.\nProof. exact ax. Qed.\nEnd F.\nModule G := F.\nModule N := F X.\nModule O.\nInclude F X.\nEnd O.",
+			want:   []string{"N.ax", "N.l", "O.ax", "O.l"},
+		},
+	}
+
+	for _, c := range cases {
+		if got := findCoqDeclarations(c.source); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseCoqAssumptions(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name:   "closed",
+			output: "Closed under the global context\n",
+		},
+		{
+			name: "axioms",
+			output: `Axioms:
+classic : forall P : Prop, P \/ ~ P
+ax : forall (A : Type) (x y : A),
+     x = y
+`,
+			want: []string{"classic", "ax"},
+		},
+		{
+			name: "section variables and unsafe constants",
+			output: `Section Variables:
+n : nat
+Constants (and their transitive dependencies) relying on the unsafe guard condition:
+loop
+Axioms:
+Provenian.Submitted.admitted : False
+`,
+			want: []string{"n", "loop", "Provenian.Submitted.admitted"},
+		},
+	}
+
+	for _, c := range cases {
+		if got := parseCoqAssumptions(c.output); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFindCoqAdmitted(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   []int
+	}{
+		{
+			name:   "admitted proofs",
+			source: "Theorem foo : False.\nProof.\n  admit.\nAdmitted.\nLemma bar : True.\nProof. Admitted.",
+			want:   []int{4, 6},
+		},
+		{
+			name:   "in comments and strings",
+			source: "(* Admitted. *)\nNotation \"'Admitted'\" := I.\nTheorem foo : True.\nProof. exact I. Qed.",
+		},
+		{
+			name:   "as a part of a name",
+			source: "Definition Admitted_count := 0.\nDefinition M.Admitted := 0.",
+		},
+	}
+
+	for _, c := range cases {
+		if got := findCoqAdmitted(c.source); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCoqFiles(t *testing.T) {
+	ws := Workspace{
+		Problem: problemmodel.Problem{
+			Files: problemmodel.LanguageFiles{Coq: []string{"B.v", "A.v", "data.txt", "Missing.v"}},
+		},
+		Attachments: []string{"A.v", "data.txt", "C.v", "B.v", "ProvenianCheck.v", "Submitted.v"},
+	}
+
+	want := []string{"B.v", "A.v", "C.v", "Submitted.v"}
+	if got := coqFiles(ws); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCoqPrepare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ws := Workspace{Dir: dir}
+	admitted := "Theorem foo : False.\nProof.\nAdmitted."
+	if err := (CoqVerifier{}).Prepare(ws, strings.NewReader(admitted)); err == nil {
+		t.Errorf("admitted: got no error")
+	} else if _, ok := err.(cheatDetected); !ok {
+		t.Errorf("admitted: got %v, want a cheat", err)
+	}
+	if _, err := os.Stat(path.Join(dir, coqSubmissionFile)); !os.IsNotExist(err) {
+		t.Errorf("admitted: the submission was written")
+	}
+
+	proved := "Theorem foo : True.\nProof. exact I. Qed."
+	if err := (CoqVerifier{}).Prepare(ws, strings.NewReader(proved)); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(path.Join(dir, coqSubmissionFile)); err != nil || string(content) != proved {
+		t.Errorf("proved: got %q %v", content, err)
+	}
+}
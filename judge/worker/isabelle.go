@@ -0,0 +1,485 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+var isabellePath = os.Getenv("ISABELLE_PATH")
+
+// The theory name used in the problem templates
+const isabelleSubmissionFile = "Submitted.thy"
+
+// The session checking the goals, built on top of the problem session
+const isabelleCheckSession = "Provenian_Check"
+
+const isabelleCheckRoot = `session ` + isabelleCheckSession + ` = "%s" +
+  theories ` + isabelleCheckSession + `
+`
+
+// The check theory imports only Pure, so that the ML code of the submission is not in its
+// environment. The statements are read in the context of the parents of the submitted theories
+// other than themselves, so that the submission cannot change their syntax.
+// The theorem and the official statement are equal if they match each other,
+// i.e. they are the same up to renaming of variables.
+const isabelleCheckTheory = `theory ` + isabelleCheckSession + `
+  imports Pure
+begin
+
+ML \<open>
+  val provenian_submitted = map Thy_Info.get_theory [%s]
+  val provenian_theory = hd provenian_submitted
+  val provenian_dependencies = Path.explode %s
+  val provenian_recorded = %s
+
+  (* Written even if nothing is found, so that the judge can tell that the check ran *)
+  val _ = File.write provenian_dependencies ""
+
+  fun provenian_local thy = exists (fn submitted => Context.eq_thy (submitted, thy)) provenian_submitted
+
+  val provenian_context =
+    Proof_Context.init_global (Theory.begin_theory ("Provenian_Statements", Position.none)
+      (distinct Context.eq_thy (filter_out provenian_local (maps Theory.parents_of provenian_submitted))))
+
+  fun provenian_check_goal name statement =
+    let
+      val thm = Global_Theory.get_thm provenian_theory name
+      val actual = Thm.prop_of thm
+      val expected = Logic.varify_global (Syntax.read_prop provenian_context statement)
+    in
+      if null (Thm.hyps_of thm) andalso
+        Pattern.matches provenian_theory (expected, actual) andalso Pattern.matches provenian_theory (actual, expected) then ()
+      else error ("Wrong statement: " ^ name ^ " proves " ^ Syntax.string_of_term provenian_context actual)
+    end
+\<close>
+
+` + isabelleAuditML + `
+` + isabellePolicyML + `
+%s
+end
+`
+
+// Commands skipping a proof or turning on an unsound mode
+var isabelleCheatWords = map[string]bool{
+	"sorry":           true,
+	"oops":            true,
+	"quick_and_dirty": true,
+	"skip_proofs":     true,
+}
+
+// Commands whose body is ML code, or which load ML code from files
+var isabelleMLCommands = map[string]bool{
+	"ML":                 true,
+	"ML_prf":             true,
+	"ML_val":             true,
+	"ML_command":         true,
+	"ML_file":            true,
+	"ML_file_debug":      true,
+	"ML_file_no_debug":   true,
+	"SML_file":           true,
+	"SML_file_debug":     true,
+	"SML_file_no_debug":  true,
+	"ML_export":          true,
+	"SML_export":         true,
+	"SML_import":         true,
+	"setup":              true,
+	"local_setup":        true,
+	"method_setup":       true,
+	"attribute_setup":    true,
+	"declaration":        true,
+	"syntax_declaration": true,
+	"simproc_setup":      true,
+	"oracle":             true,
+
+	"parse_ast_translation":   true,
+	"parse_translation":       true,
+	"print_translation":       true,
+	"typed_print_translation": true,
+	"print_ast_translation":   true,
+}
+
+// Proof methods whose argument is ML code
+var isabelleMLMethods = map[string]bool{
+	"tactic":     true,
+	"raw_tactic": true,
+}
+
+// ML identifiers skipping a proof or turning on an unsound mode, or reaching the global
+// ML environment, which the check session shares with the submission.
+// Matching them is advisory only, as ML code can reach the same values without spelling
+// the names; the audit of the axioms and the oracles in the check is the real defense.
+var isabelleMLCheats = []string{"quick_and_dirty", "Skip_Proof", "cheat_tac", "ML_Name_Space", "PolyML"}
+
+type IsabelleVerifier struct {
+	isabellePath string
+}
+
+func init() {
+	RegisterVerifier("isabelle", IsabelleVerifier{isabellePath: isabellePath})
+	for language, path := range versionedPaths("ISABELLE_VERSIONS", "isabelle") {
+		RegisterVerifier(language, IsabelleVerifier{isabellePath: path})
+	}
+}
+
+// The theory files submitted besides Submitted.thy
+var isabelleTheoryFilename = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_']*\.thy$`)
+
+// Prepare puts the submitted theories into the workspace, with the ROOT file generated
+// from the session of the problem unless the attachments have one.
+// The theories with cheats are rejected before anything is built.
+func (verifier IsabelleVerifier) Prepare(ws Workspace, code io.Reader) error {
+	attachments := map[string]bool{"ROOT": true, isabelleSubmissionFile: true, isabelleCheckSession + ".thy": true}
+	for _, attachment := range ws.Attachments {
+		attachments[attachment] = true
+	}
+	for _, file := range ws.Files {
+		if !isabelleTheoryFilename.MatchString(file.Filename) {
+			return rejection{message: "Not a theory file: " + file.Filename}
+		}
+		if attachments[file.Filename] {
+			return rejection{message: "The file is given by the problem: " + file.Filename}
+		}
+	}
+
+	if _, err := os.Stat(path.Join(ws.Dir, "ROOT")); os.IsNotExist(err) {
+		root, err := isabelleGeneratedRoot(ws.Problem.Isabelle, ws.Attachments, ws.Files)
+		if err != nil {
+			return err
+		}
+
+		if err := writeFile(path.Join(ws.Dir, "ROOT"), strings.NewReader(root)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if len(ws.Files) > 0 {
+		return rejection{message: "This problem accepts only " + isabelleSubmissionFile}
+	}
+
+	source, err := ioutil.ReadAll(code)
+	if err != nil {
+		return err
+	}
+	if err := checkIsabelleCheats(ws, source); err != nil {
+		return err
+	}
+
+	// Before the submission is written, so that building the problem sessions cannot depend on it
+	if err := verifier.prepareHeaps(ws); err != nil {
+		return err
+	}
+
+	if err := writeFile(path.Join(ws.Dir, isabelleSubmissionFile), bytes.NewReader(source)); err != nil {
+		return err
+	}
+	for _, file := range ws.Files {
+		if err := writeFile(path.Join(ws.Dir, file.Filename), bytes.NewReader(file.Content)); err != nil {
+			return err
+		}
+	}
+
+	if err := checkIsabellePolicy(ws); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// isabelleMLString quotes the text as an ML string. Every byte but the printable ASCII
+// is escaped, so that symbols like \<close> do not appear in the ML source.
+func isabelleMLString(text string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '"':
+			quoted.WriteString(`\"`)
+		case c == '\\' || c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&quoted, "\\%03d", c)
+		default:
+			quoted.WriteByte(c)
+		}
+	}
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
+
+// writeIsabelleCheck writes the check session, replacing whatever is at its directory
+func writeIsabelleCheck(ws Workspace) error {
+	root, err := ioutil.ReadFile(path.Join(ws.Dir, "ROOT"))
+	if err != nil {
+		return err
+	}
+
+	session, ok := isabelleSubmissionSession(parseIsabelleRoot(string(root)))
+	if !ok {
+		return errors.New("no session in ROOT has the theory Submitted")
+	}
+
+	var theories []string
+	for _, filename := range isabelleSubmittedFiles(ws) {
+		theories = append(theories, isabelleMLString(session.Name+"."+strings.TrimSuffix(filename, ".thy")))
+	}
+
+	var checks strings.Builder
+	for _, goal := range ws.Goals {
+		name := goal.Name
+		if !strings.Contains(name, ".") {
+			name = "Submitted." + name
+		}
+
+		fmt.Fprintf(&checks, "ML \\<open>provenian_check_goal %s %s\\<close>\n", isabelleMLString(name), isabelleMLString(goal.Statement))
+		fmt.Fprintf(&checks, "ML \\<open>provenian_audit_goal %s\\<close>\n", isabelleMLString(name))
+	}
+	if len(ws.Goals) == 0 {
+		checks.WriteString("ML \\<open>provenian_audit_all ()\\<close>\n")
+	}
+
+	if len(ws.Problem.Policy.Imports) > 0 {
+		localTheories, err := isabelleLocalTheories(ws)
+		if err != nil {
+			return err
+		}
+
+		var locals []string
+		for theory := range localTheories {
+			locals = append(locals, isabelleMLString(theory))
+		}
+		sort.Strings(locals)
+
+		fmt.Fprintf(&checks, "ML \\<open>provenian_policy_imports [%s]\\<close>\n", strings.Join(locals, ", "))
+	}
+
+	dir := path.Join(ws.Dir, isabelleCheckSession)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := writeFile(path.Join(dir, "ROOT"), strings.NewReader(fmt.Sprintf(isabelleCheckRoot, session.Name))); err != nil {
+		return err
+	}
+
+	// The generated ROOT records the proofs of the submission for the audit
+	recorded := "true"
+	for _, attachment := range ws.Attachments {
+		if attachment == "ROOT" {
+			recorded = "false"
+		}
+	}
+
+	check := fmt.Sprintf(isabelleCheckTheory, strings.Join(theories, ", "), isabelleMLString(path.Join(dir, isabelleDependenciesFile)), recorded, checks.String())
+	return writeFile(path.Join(dir, isabelleCheckSession+".thy"), strings.NewReader(check))
+}
+
+// Run builds the sessions in the workspace, and then the check session.
+// quick_and_dirty and skip_proofs are turned off explicitly so that
+// the session options of the problem cannot let skipped proofs through.
+// The check session is built in a workspace of its own, where the submission never ran:
+// it gets the files the judge wrote, checked to be unchanged by the build, the heaps of the
+// sessions of the ROOT and a new Isabelle home, so that the submission cannot tamper with
+// the settings, the ROOT or the outputs of the check.
+func (verifier IsabelleVerifier) Run(ws Workspace) (Execution, error) {
+	build := func(ws Workspace, args ...string) (Execution, error) {
+		return runCommand(ws, verifier.isabellePath, append([]string{"build", "-o", "quick_and_dirty=false", "-o", "skip_proofs=false"}, args...)...)
+	}
+
+	files, err := takeSnapshot(ws.Dir)
+	if err != nil {
+		return Execution{}, err
+	}
+
+	// The heaps are kept for the check session to be built on top of
+	execution, err := build(ws, "-b", "-D", ws.Dir)
+	if err != nil || execution.ExitCode != 0 {
+		return execution, err
+	}
+
+	checkDir, err := ioutil.TempDir(path.Dir(ws.Dir), path.Base(ws.Dir)+"-check-")
+	if err != nil {
+		return Execution{}, err
+	}
+	defer os.RemoveAll(checkDir)
+
+	checkWs := ws
+	checkWs.Dir = checkDir
+	if err := verifier.prepareCheck(ws, checkWs, files); err != nil {
+		return Execution{}, err
+	}
+
+	check, err := build(checkWs, "-d", checkDir, "-D", path.Join(checkDir, isabelleCheckSession))
+	if err != nil {
+		return Execution{}, err
+	}
+
+	// The outputs of the check are read from the workspace by Classify
+	outputs := path.Join(ws.Dir, isabelleCheckSession)
+	if err := os.RemoveAll(outputs); err != nil {
+		return Execution{}, err
+	}
+	if err := os.MkdirAll(outputs, 0755); err != nil {
+		return Execution{}, err
+	}
+	if err := copyFile(path.Join(checkDir, isabelleCheckSession, isabelleDependenciesFile), path.Join(outputs, isabelleDependenciesFile)); err != nil && !os.IsNotExist(err) {
+		return Execution{}, err
+	}
+
+	// The submission is fine by itself but the goal check failed
+	check.Log = execution.Log + strings.Replace(check.Log, checkDir, ws.Dir, -1)
+	check.WrongStatement = check.ExitCode != 0
+
+	return check, nil
+}
+
+// prepareCheck puts the files of the snapshot of the workspace, the heaps built in its sandbox
+// and the check session into the workspace of the check
+func (verifier IsabelleVerifier) prepareCheck(ws Workspace, checkWs Workspace, files snapshot) error {
+	if err := files.copyTo(ws.Dir, checkWs.Dir); err != nil {
+		return err
+	}
+
+	// Without the sandbox, the heaps are in the home of the judge
+	if sandboxEnabled {
+		root, err := ioutil.ReadFile(path.Join(checkWs.Dir, "ROOT"))
+		if err != nil {
+			return err
+		}
+
+		sessions := map[string]bool{}
+		for _, session := range parseIsabelleRoot(string(root)) {
+			sessions[session.Name] = true
+		}
+
+		env, err := verifier.environment()
+		if err != nil {
+			return err
+		}
+
+		heaps := path.Join(sandboxHome, env.HomeUser, "heaps")
+		if err := copyIsabelleHeaps(path.Join(ws.Dir, heaps), path.Join(checkWs.Dir, heaps), sessions); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := verifier.prepareHeaps(checkWs); err != nil {
+		return err
+	}
+
+	return writeIsabelleCheck(checkWs)
+}
+
+// isabelleSubmittedFiles are the theory files of the submission
+func isabelleSubmittedFiles(ws Workspace) []string {
+	filenames := []string{isabelleSubmissionFile}
+	for _, file := range ws.Files {
+		filenames = append(filenames, file.Filename)
+	}
+
+	return filenames
+}
+
+// checkIsabelleCheats scans the submitted theories for cheats, as they were submitted
+// rather than as they are in the workspace, which the sandbox can write to
+func checkIsabelleCheats(ws Workspace, source []byte) error {
+	sources := map[string][]byte{isabelleSubmissionFile: source}
+	for _, file := range ws.Files {
+		sources[file.Filename] = file.Content
+	}
+
+	var message strings.Builder
+	var diagnostics []model.Diagnostic
+	for _, filename := range isabelleSubmittedFiles(ws) {
+		for _, cheat := range findIsabelleCheats(string(sources[filename])) {
+			fmt.Fprintf(&message, "%s:%d: %s\n", filename, cheat.Line, cheat.Text)
+			diagnostics = append(diagnostics, model.Diagnostic{
+				Theory:   strings.TrimSuffix(filename, ".thy"),
+				Line:     cheat.Line,
+				Severity: model.SeverityError,
+				Message:  "Cheat: " + cheat.Text,
+			})
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return cheatDetected{message: message.String(), diagnostics: diagnostics}
+	}
+
+	return nil
+}
+
+func (verifier IsabelleVerifier) Classify(ws Workspace, execution Execution) (model.Result, error) {
+	filenames := isabelleSubmittedFiles(ws)
+
+	result := classifyExecution(execution)
+	result.Diagnostics = parseIsabelleDiagnostics(ws, execution.Log)
+	if result.Code != model.V("").Code {
+		return result, nil
+	}
+
+	result, err := checkIsabelleImports(ws, result)
+	if err != nil || result.Code != model.V("").Code {
+		return result, err
+	}
+
+	return auditIsabelle(ws, filenames, result)
+}
+
+type isabelleCheat struct {
+	Line int
+	Text string
+}
+
+// findIsabelleCheats lists the places where the source skips a proof or
+// turns on an unsound mode, including the ones inside the ML code of commands and proof methods.
+// Comments, strings and text blocks are not reported.
+func findIsabelleCheats(source string) []isabelleCheat {
+	var cheats []isabelleCheat
+
+	mlPending := false
+	for _, token := range tokenizeIsabelle(source) {
+		switch token.Kind {
+		case isabelleWord:
+			if isabelleCheatWords[token.Text] {
+				cheats = append(cheats, isabelleCheat{Line: token.Line, Text: token.Text})
+			}
+			if isabelleMLCommands[token.Text] || isabelleMLMethods[token.Text] {
+				mlPending = true
+			}
+		case isabelleCartouche, isabelleVerbatim, isabelleString, isabelleAltString:
+			if mlPending {
+				cheats = append(cheats, findMLCheats(token)...)
+			}
+			mlPending = false
+		}
+	}
+
+	return cheats
+}
+
+// findMLCheats matches the ML code against isabelleMLCheats, which is advisory only
+func findMLCheats(token isabelleToken) []isabelleCheat {
+	var cheats []isabelleCheat
+
+	for offset, line := range strings.Split(token.Content(), "\n") {
+		for _, word := range isabelleMLCheats {
+			if strings.Contains(line, word) {
+				cheats = append(cheats, isabelleCheat{Line: token.Line + offset, Text: word})
+			}
+		}
+	}
+
+	return cheats
+}
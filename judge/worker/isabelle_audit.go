@@ -0,0 +1,227 @@
+package worker
+
+import (
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+// The check session writes the axioms and the oracles the theorems depend on here,
+// one per line as "axiom<TAB>theorem<TAB>axiom name" or "oracle<TAB>theorem<TAB>oracle name",
+// and the parents of the submitted theories as "import<TAB>theory<TAB>parent"
+const isabelleDependenciesFile = "dependencies"
+
+// The axioms are the ones the submitted theories add, and a theorem depends on an axiom if its
+// proof uses the fact of the axiom. The uses are recorded only if the submission is built with
+// record_proofs = 1 (as the generated ROOT does), otherwise every axiom counts as a dependency,
+// as well as the axioms without their facts (like the unnamed ones).
+// The oracle names are printed as ML values, which are strings or (name, position) pairs
+// depending on the version of Isabelle.
+// Without goals, every fact of the submitted theories is audited.
+const isabelleAuditML = `ML \<open>
+  val provenian_axioms =
+    let
+      val inherited = Symtab.make_set (map #1 (Theory.all_axioms_of (Proof_Context.theory_of provenian_context)))
+    in
+      distinct (op =) (filter_out (Symtab.defined inherited) (map #1 (maps Theory.all_axioms_of provenian_submitted)))
+    end
+
+  fun provenian_audit name thm =
+    let
+      val used = Proofterm.fold_body_thms (fn {name, ...} => Symtab.insert_set name) [Thm.proof_body_of thm] (Symtab.make_set [name])
+      fun depends axiom =
+        not provenian_recorded orelse Symtab.defined used axiom orelse not (can (Global_Theory.get_thms provenian_theory) axiom)
+      val axioms = filter depends provenian_axioms
+      val oracles = map (space_implode " " o split_lines o @{make_string} o #1) (Thm_Deps.all_oracles [thm])
+    in
+      File.append provenian_dependencies (implode
+        (map (fn axiom => "axiom\t" ^ name ^ "\t" ^ axiom ^ "\n") axioms @
+          map (fn oracle => "oracle\t" ^ name ^ "\t" ^ oracle ^ "\n") oracles))
+    end
+
+  fun provenian_audit_goal name = provenian_audit name (Global_Theory.get_thm provenian_theory name)
+
+  fun provenian_audit_all () =
+    let
+      val inherited = Symtab.make_set (map #1 (Global_Theory.all_thms_of (Proof_Context.theory_of provenian_context) false))
+      val facts = maps (fn thy => Global_Theory.all_thms_of thy false) provenian_submitted
+    in
+      List.app (uncurry provenian_audit) (filter_out (Symtab.defined inherited o #1) facts)
+    end
+\<close>
+`
+
+// The commands which end an axiomatization
+var isabelleCommands = map[string]bool{
+	"lemma":          true,
+	"theorem":        true,
+	"corollary":      true,
+	"proposition":    true,
+	"schematic_goal": true,
+	"lemmas":         true,
+	"definition":     true,
+	"abbreviation":   true,
+	"fun":            true,
+	"function":       true,
+	"primrec":        true,
+	"datatype":       true,
+	"codatatype":     true,
+	"type_synonym":   true,
+	"typedecl":       true,
+	"typedef":        true,
+	"record":         true,
+	"inductive":      true,
+	"inductive_set":  true,
+	"coinductive":    true,
+	"axiomatization": true,
+	"consts":         true,
+	"notation":       true,
+	"no_notation":    true,
+	"declare":        true,
+	"locale":         true,
+	"interpretation": true,
+	"instantiation":  true,
+	"instance":       true,
+	"class":          true,
+	"context":        true,
+	"end":            true,
+	"text":           true,
+	"section":        true,
+	"subsection":     true,
+	"value":          true,
+}
+
+// The first string in an ML value
+var isabelleQuotedString = regexp.MustCompile(`"([^"]*)"`)
+
+type isabelleAxiom struct {
+	Line int
+	Name string
+}
+
+// findIsabelleAxioms lists the axioms declared after "where" of the axiomatizations in the source.
+// Unnamed axioms have empty names.
+func findIsabelleAxioms(source string) []isabelleAxiom {
+	var tokens []isabelleToken
+	for _, token := range tokenizeIsabelle(source) {
+		if token.Kind != isabelleSpace && token.Kind != isabelleComment {
+			tokens = append(tokens, token)
+		}
+	}
+
+	var axioms []isabelleAxiom
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].Kind != isabelleWord || tokens[i].Text != "axiomatization" {
+			continue
+		}
+
+		// Specifications start after "where" and every "and" following it
+		specifications := false
+		start := false
+		for i++; i < len(tokens); i++ {
+			token := tokens[i]
+			if token.Kind == isabelleWord && (isabelleCommands[token.Text] || isabelleMLCommands[token.Text]) {
+				i--
+				break
+			}
+
+			if token.Kind == isabelleWord && token.Text == "where" {
+				specifications, start = true, true
+				continue
+			}
+			if token.Kind == isabelleWord && token.Text == "and" {
+				start = specifications
+				continue
+			}
+			if !start {
+				continue
+			}
+			start = false
+
+			if token.Kind == isabelleWord {
+				// name [attributes]:
+				next := i + 1
+				if next < len(tokens) && tokens[next].Text == "[" {
+					for next < len(tokens) && tokens[next].Text != "]" {
+						next++
+					}
+					next++
+				}
+				if next < len(tokens) && tokens[next].Text == ":" {
+					axioms = append(axioms, isabelleAxiom{Line: token.Line, Name: token.Text})
+					i = next
+					continue
+				}
+			}
+
+			axioms = append(axioms, isabelleAxiom{Line: token.Line})
+		}
+	}
+
+	return axioms
+}
+
+// auditIsabelle records the axioms and the oracles the check session found on the result,
+// and turns the result into CD if the problem does not allow some of them.
+// The axioms not allowed are reported at the axiomatizations declaring them.
+func auditIsabelle(ws Workspace, filenames []string, result model.Result) (model.Result, error) {
+	// The check writes the file whenever it succeeds, so a missing one is never read as nothing found
+	found, err := ioutil.ReadFile(path.Join(ws.Dir, isabelleCheckSession, isabelleDependenciesFile))
+	if err != nil {
+		return model.Result{}, err
+	}
+
+	var dependencies []model.Dependency
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(found), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || fields[0] != model.DependencyAxiom && fields[0] != model.DependencyOracle || seen[line] {
+			continue
+		}
+		seen[line] = true
+
+		name := fields[2]
+		if quoted := isabelleQuotedString.FindStringSubmatch(name); fields[0] == model.DependencyOracle && quoted != nil {
+			name = quoted[1]
+		}
+
+		dependencies = append(dependencies, model.Dependency{Kind: fields[0], Name: name, Theorem: fields[1]})
+	}
+
+	result = auditDependencies(ws, "isabelle", result, dependencies)
+	if result.Code != model.CD("").Code {
+		return result, nil
+	}
+
+	reported := map[string]bool{}
+	for _, dependency := range result.Dependencies {
+		reported[dependency.Name] = reported[dependency.Name] || dependency.Kind == model.DependencyAxiom && !dependency.Allowed
+	}
+
+	for _, filename := range filenames {
+		source, err := ioutil.ReadFile(path.Join(ws.Dir, filename))
+		if err != nil {
+			return model.Result{}, err
+		}
+
+		theory := strings.TrimSuffix(filename, ".thy")
+		for _, axiom := range findIsabelleAxioms(string(source)) {
+			name := theory + "." + axiom.Name
+			if axiom.Name == "" || !reported[name] {
+				continue
+			}
+
+			result.Diagnostics = append(result.Diagnostics, model.Diagnostic{
+				Theory:   theory,
+				Line:     axiom.Line,
+				Severity: model.SeverityError,
+				Message:  "Axiom not allowed: " + name,
+			})
+		}
+	}
+
+	return result, nil
+}
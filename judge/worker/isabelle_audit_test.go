@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+func TestFindIsabelleAxioms(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   []isabelleAxiom
+	}{
+		{
+			name:   "named",
+			source: "axiomatization where\n  ax: \"False\"",
+			want:   []isabelleAxiom{{Line: 2, Name: "ax"}},
+		},
+		{
+			name:   "unnamed",
+			source: "axiomatization where \"False\"",
+			want:   []isabelleAxiom{{Line: 1}},
+		},
+		{
+			name:   "attributes and and",
+			source: "axiomatization f :: \"nat ⇒ nat\" and g :: \"nat ⇒ nat\" where\n  f_def [simp]: \"f x = x\" and\n  \"g x = x\" and\n  g_def: \"g = f\"",
+			want:   []isabelleAxiom{{Line: 2, Name: "f_def"}, {Line: 3}, {Line: 4, Name: "g_def"}},
+		},
+		{
+			name:   "constants only",
+			source: "axiomatization f :: \"nat ⇒ nat\" and g :: \"nat ⇒ nat\"\nlemma ax: \"False\" sorry",
+		},
+		{
+			name:   "ends at the next command",
+			source: "axiomatization where ax: \"False\"\nlemma \"x = x\" and y: \"y = y\" by simp\naxiomatization where (* c *) ‹True›",
+			want:   []isabelleAxiom{{Line: 1, Name: "ax"}, {Line: 3}},
+		},
+		{
+			name:   "in comments and texts",
+			source: "(* axiomatization where ax: \"False\" *)\ntext ‹axiomatization where ax: False›",
+		},
+	}
+
+	for _, c := range cases {
+		if got := findIsabelleAxioms(c.source); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAuditIsabelle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ws := Workspace{
+		Problem: problemmodel.Problem{Axioms: problemmodel.LanguageAxioms{Isabelle: []string{"Submitted.ok"}}},
+		Dir:     dir,
+	}
+	source := "theory Submitted imports Main begin\naxiomatization where\n  ok: \"True\" and\n  bad: \"False\"\nend\n"
+	if err := ioutil.WriteFile(path.Join(dir, "Submitted.thy"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := auditIsabelle(ws, []string{"Submitted.thy"}, model.V("")); err == nil {
+		t.Errorf("missing dependencies: got no error")
+	}
+
+	if err := os.MkdirAll(path.Join(dir, isabelleCheckSession), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name         string
+		dependencies string
+		code         string
+		diagnostics  []model.Diagnostic
+	}{
+		{
+			name:         "nothing found",
+			dependencies: "",
+			code:         model.V("").Code,
+		},
+		{
+			name:         "allowed",
+			dependencies: "axiom\tSubmitted.foo\tSubmitted.ok\nimport\tSubmitted\tMain\n",
+			code:         model.V("").Code,
+		},
+		{
+			name:         "not allowed",
+			dependencies: "axiom\tSubmitted.foo\tSubmitted.ok\naxiom\tSubmitted.foo\tSubmitted.bad\noracle\tSubmitted.foo\t(\"skip_proof\", {})\n",
+			code:         model.CD("").Code,
+			diagnostics: []model.Diagnostic{{
+				Theory:   "Submitted",
+				Line:     4,
+				Severity: model.SeverityError,
+				Message:  "Axiom not allowed: Submitted.bad",
+			}},
+		},
+	}
+
+	for _, c := range cases {
+		if err := ioutil.WriteFile(path.Join(dir, isabelleCheckSession, isabelleDependenciesFile), []byte(c.dependencies), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := auditIsabelle(ws, []string{"Submitted.thy"}, model.V(""))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if result.Code != c.code || !reflect.DeepEqual(result.Diagnostics, c.diagnostics) {
+			t.Errorf("%s: got %s %+v, want %s %+v", c.name, result.Code, result.Diagnostics, c.code, c.diagnostics)
+		}
+	}
+}
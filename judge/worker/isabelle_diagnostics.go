@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+// Messages in the output of isabelle build are prefixed by "*** " (errors) or "### " (warnings),
+// with positions like (line 5 of "/path/Submitted.thy").
+// The last line of a message tells the command it is reported at.
+var isabellePosition = regexp.MustCompile(`\(line (\d+) of "([^"]*)"`)
+var isabelleAtCommand = regexp.MustCompile(`^At command "([^"]*)" \(line (\d+) of "([^"]*)"\)`)
+
+var isabelleSeverities = map[string]string{
+	"*** ": model.SeverityError,
+	"### ": model.SeverityWarning,
+}
+
+type isabelleMessage struct {
+	severity string
+	lines    []string
+	command  string
+	line     int
+	file     string
+}
+
+// parseIsabelleDiagnostics collects the errors and the warnings from the output of isabelle build.
+// The column is known only for the messages reported at a command of a submitted theory.
+func parseIsabelleDiagnostics(ws Workspace, log string) []model.Diagnostic {
+	var diagnostics []model.Diagnostic
+	seen := map[model.Diagnostic]bool{}
+	sources := map[string][]isabelleToken{}
+
+	var message *isabelleMessage
+	flush := func() {
+		if message == nil {
+			return
+		}
+
+		diagnostic, ok := message.diagnostic(ws, sources)
+		if ok && !seen[diagnostic] {
+			seen[diagnostic] = true
+			diagnostics = append(diagnostics, diagnostic)
+		}
+		message = nil
+	}
+
+	for _, line := range strings.Split(log, "\n") {
+		severity, ok := isabelleSeverities[prefixOf(line, 4)]
+		if !ok {
+			flush()
+			continue
+		}
+
+		if message != nil && message.severity != severity {
+			flush()
+		}
+		if message == nil {
+			message = &isabelleMessage{severity: severity}
+		}
+
+		text := line[4:]
+		if match := isabelleAtCommand.FindStringSubmatch(text); match != nil {
+			message.command = match[1]
+			message.line, _ = strconv.Atoi(match[2])
+			message.file = match[3]
+			flush()
+			continue
+		}
+
+		message.lines = append(message.lines, text)
+	}
+	flush()
+
+	return diagnostics
+}
+
+func prefixOf(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+
+	return s[:n]
+}
+
+func (message *isabelleMessage) diagnostic(ws Workspace, sources map[string][]isabelleToken) (model.Diagnostic, bool) {
+	text := strings.TrimSpace(strings.Join(message.lines, "\n"))
+	if text == "" {
+		return model.Diagnostic{}, false
+	}
+
+	line, file := message.line, message.file
+	if file == "" {
+		if match := isabellePosition.FindStringSubmatch(text); match != nil {
+			line, _ = strconv.Atoi(match[1])
+			file = match[2]
+		}
+	}
+
+	diagnostic := model.Diagnostic{
+		Line:     line,
+		Severity: message.severity,
+		// Show the theories in the workspace by their filenames
+		Message: strings.Replace(text, ws.Dir+"/", "", -1),
+	}
+	if file != "" {
+		diagnostic.Theory = strings.TrimSuffix(path.Base(file), ".thy")
+	}
+	if message.command != "" {
+		diagnostic.Column = isabelleCommandColumn(ws, sources, file, line, message.command)
+	}
+
+	return diagnostic, true
+}
+
+// isabelleCommandColumn finds the command on the line of a submitted theory,
+// and returns its column counted in characters of the source.
+// The paths come from the log, so only the submitted files in the workspace are read.
+func isabelleCommandColumn(ws Workspace, sources map[string][]isabelleToken, file string, line int, command string) int {
+	submitted := false
+	for _, filename := range isabelleSubmittedFiles(ws) {
+		if file == path.Join(ws.Dir, filename) {
+			submitted = true
+		}
+	}
+	if !submitted {
+		return 0
+	}
+
+	tokens, ok := sources[file]
+	if !ok {
+		if info, err := os.Lstat(file); err == nil && info.Mode().IsRegular() {
+			if source, err := ioutil.ReadFile(file); err == nil {
+				tokens = tokenizeIsabelle(string(source))
+			}
+		}
+		sources[file] = tokens
+	}
+
+	column := 1
+	for _, token := range tokens {
+		if token.Line == line && token.Kind == isabelleWord && token.Text == command {
+			return column
+		}
+
+		if newline := strings.LastIndex(token.Text, "\n"); newline >= 0 {
+			column = utf8.RuneCountInString(token.Text[newline+1:]) + 1
+		} else {
+			column += utf8.RuneCountInString(token.Text)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+func TestParseIsabelleDiagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := "theory Submitted imports Main begin\n\ntext ‹α› lemma foo: \"x = y\"\n  by simp\n\nend\n"
+	if err := ioutil.WriteFile(path.Join(dir, "Submitted.thy"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// An attachment, and a submitted file the sandbox replaced by a link
+	if err := ioutil.WriteFile(path.Join(dir, "Defs.thy"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(path.Join(dir, "Submitted.thy"), path.Join(dir, "Extra.thy")); err != nil {
+		t.Fatal(err)
+	}
+	ws := Workspace{Dir: dir, Files: []WorkspaceFile{{Filename: "Extra.thy"}}}
+	submitted := dir + "/Submitted.thy"
+
+	cases := []struct {
+		name string
+		log  string
+		want []model.Diagnostic
+	}{
+		{
+			name: "error at a command",
+			log: `Running Provenian ...
+Provenian FAILED
+*** Failed to finish proof (line 3 of "` + submitted + `"):
+*** goal (1 subgoal):
+***  1. x = y
+*** At command "lemma" (line 3 of "` + submitted + `")
+`,
+			want: []model.Diagnostic{{
+				Theory:   "Submitted",
+				Line:     3,
+				Column:   10,
+				Severity: model.SeverityError,
+				Message:  "Failed to finish proof (line 3 of \"Submitted.thy\"):\ngoal (1 subgoal):\n 1. x = y",
+			}},
+		},
+		{
+			name: "warning with a position",
+			log: `### Unused theorems (line 4 of "` + submitted + `")
+Finished Provenian
+`,
+			want: []model.Diagnostic{{
+				Theory:   "Submitted",
+				Line:     4,
+				Severity: model.SeverityWarning,
+				Message:  "Unused theorems (line 4 of \"Submitted.thy\")",
+			}},
+		},
+		{
+			name: "outside the workspace",
+			log: `*** Bad theory
+*** At command "theory" (line 1 of "/isabelle/src/HOL/Main.thy")
+`,
+			want: []model.Diagnostic{{
+				Theory:   "Main",
+				Line:     1,
+				Severity: model.SeverityError,
+				Message:  "Bad theory",
+			}},
+		},
+		{
+			name: "without a position, and repeated",
+			log: `*** Undefined session
+### Some warning
+*** Undefined session
+`,
+			want: []model.Diagnostic{
+				{Severity: model.SeverityError, Message: "Undefined session"},
+				{Severity: model.SeverityWarning, Message: "Some warning"},
+			},
+		},
+		{
+			name: "at a command of an attachment",
+			log: `*** Bad lemma
+*** At command "lemma" (line 3 of "` + dir + `/Defs.thy")
+`,
+			want: []model.Diagnostic{{Theory: "Defs", Line: 3, Severity: model.SeverityError, Message: "Bad lemma"}},
+		},
+		{
+			name: "at a command of a link",
+			log: `*** Bad lemma
+*** At command "lemma" (line 3 of "` + dir + `/Extra.thy")
+`,
+			want: []model.Diagnostic{{Theory: "Extra", Line: 3, Severity: model.SeverityError, Message: "Bad lemma"}},
+		},
+		{
+			name: "at a command of a path out of the workspace",
+			log: `*** Bad lemma
+*** At command "lemma" (line 3 of "` + dir + `/../` + path.Base(dir) + `/Submitted.thy")
+`,
+			want: []model.Diagnostic{{Theory: "Submitted", Line: 3, Severity: model.SeverityError, Message: "Bad lemma"}},
+		},
+		{
+			name: "no messages",
+			log:  "Building Provenian ...\nFinished Provenian (0:00:01 elapsed time)\n",
+		},
+	}
+
+	for _, c := range cases {
+		if got := parseIsabelleDiagnostics(ws, c.log); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The heap images of the problem sessions are cached under ISABELLE_HEAP_CACHE if given,
+// so that a submission builds only its own session on top of them.
+// The cache needs the sandbox, which gives every submission its own Isabelle home.
+var isabelleHeapCache = os.Getenv("ISABELLE_HEAP_CACHE")
+
+// Building the heaps of a problem does not count against the time limit of the submission
+// which happens to trigger it, but it is stopped after this many seconds, or at the deadline of Prepare
+var isabelleHeapTimeout = time.Duration(getenvInt("ISABELLE_HEAP_TIMEOUT", 3600)) * time.Second
+
+// isabelleEnvironment is what the heap cache needs to know about the installed Isabelle
+type isabelleEnvironment struct {
+	Version string
+	// HomeUser is ISABELLE_HOME_USER relative to HOME
+	HomeUser string
+}
+
+// The environments of the installed versions of Isabelle by their paths, looked up once for each
+var isabelleEnvironments = map[string]isabelleEnvironment{}
+var isabelleEnvironmentsLock sync.Mutex
+
+func (verifier IsabelleVerifier) environment() (isabelleEnvironment, error) {
+	isabelleEnvironmentsLock.Lock()
+	defer isabelleEnvironmentsLock.Unlock()
+
+	if env, ok := isabelleEnvironments[verifier.isabellePath]; ok {
+		return env, nil
+	}
+
+	const home = "/provenian-home"
+
+	version, err := exec.Command(verifier.isabellePath, "version").Output()
+	if err != nil {
+		return isabelleEnvironment{}, err
+	}
+
+	cmd := exec.Command(verifier.isabellePath, "getenv", "-b", "ISABELLE_HOME_USER")
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	homeUser, err := cmd.Output()
+	if err != nil {
+		return isabelleEnvironment{}, err
+	}
+
+	env := isabelleEnvironment{
+		Version:  strings.TrimSpace(string(version)),
+		HomeUser: strings.TrimPrefix(strings.TrimSpace(string(homeUser)), home+"/"),
+	}
+	isabelleEnvironments[verifier.isabellePath] = env
+
+	return env, nil
+}
+
+// isabelleHeapKey identifies the heaps built from the attachments and the ROOT of the problem.
+// The generated ROOT is hashed without the submitted theories, which are not in the heaps.
+func isabelleHeapKey(ws Workspace, version string) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00", ws.Problem.ID, version)
+
+	attachments := append([]string{}, ws.Attachments...)
+	sort.Strings(attachments)
+
+	generated := true
+	for _, attachment := range attachments {
+		generated = generated && attachment != "ROOT"
+	}
+	if generated {
+		root, err := isabelleGeneratedRoot(ws.Problem.Isabelle, ws.Attachments, nil)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(hash, "ROOT (generated)\x00%s\x00", root)
+	}
+
+	for _, attachment := range attachments {
+		content, err := ioutil.ReadFile(path.Join(ws.Dir, attachment))
+		if err != nil {
+			return "", err
+		}
+
+		digest := sha256.Sum256(content)
+		fmt.Fprintf(hash, "%s\x00%x\x00", attachment, digest)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// prepareHeaps makes the heaps of the parent of the submission session available
+// to the workspace, building them first if they are not cached yet
+func (verifier IsabelleVerifier) prepareHeaps(ws Workspace) error {
+	if isabelleHeapCache == "" || !sandboxEnabled {
+		return nil
+	}
+
+	root, err := ioutil.ReadFile(path.Join(ws.Dir, "ROOT"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	session, ok := isabelleSubmissionSession(parseIsabelleRoot(string(root)))
+	if !ok || session.Parent == "" {
+		return nil
+	}
+
+	env, err := verifier.environment()
+	if err != nil {
+		return err
+	}
+
+	key, err := isabelleHeapKey(ws, env.Version)
+	if err != nil {
+		return err
+	}
+
+	cached := path.Join(isabelleHeapCache, key)
+	if _, err := os.Stat(cached); os.IsNotExist(err) {
+		if err := verifier.buildHeaps(ws, env, session.Parent, cached); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// Isabelle looks for the heaps in the directories of ISABELLE_PATH, after its own output
+	settings := path.Join(ws.Dir, sandboxHome, env.HomeUser, "etc")
+	if err := os.MkdirAll(settings, 0755); err != nil {
+		return err
+	}
+
+	return writeFile(path.Join(settings, "settings"), strings.NewReader(fmt.Sprintf("ISABELLE_PATH=\"$ISABELLE_PATH:%s\"\n", cached)))
+}
+
+// buildHeaps builds the session and its ancestors in the sandbox of the workspace,
+// and moves the heaps into the cache
+func (verifier IsabelleVerifier) buildHeaps(ws Workspace, env isabelleEnvironment, session string, cached string) error {
+	if deadline := time.Now().Add(isabelleHeapTimeout); ws.Deadline.IsZero() || deadline.Before(ws.Deadline) {
+		ws.Deadline = deadline
+	}
+
+	execution, err := runCommand(ws, verifier.isabellePath, "build", "-b", "-o", "quick_and_dirty=false", "-o", "skip_proofs=false", "-d", ws.Dir, session)
+	if err != nil {
+		return err
+	}
+	if execution.Canceled {
+		return errCanceled
+	}
+	if execution.TimedOut || execution.MemoryExceeded || execution.ExitCode != 0 {
+		return fmt.Errorf("failed to build the problem session %s:\n%s", session, execution.Log)
+	}
+
+	building, err := ioutil.TempDir(isabelleHeapCache, ".building-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(building)
+
+	// Copied rather than linked, so that the submissions cannot write to the cache
+	heaps := path.Join(ws.Dir, sandboxHome, env.HomeUser, "heaps")
+	if err := copyTree(heaps, building); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Chmod(building, 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(heaps); err != nil {
+		return err
+	}
+
+	// Another worker may have cached the same heaps in the meantime
+	if err := os.Rename(building, cached); err != nil {
+		if _, statErr := os.Stat(cached); statErr != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyTree copies the regular files and the directories under src into dst,
+// readable by everyone and writable only by the judge
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relative)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return copyFile(file, target)
+	})
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// copyIsabelleHeaps copies the heaps and the build logs of the sessions (like heaps/<ML identifier>/A
+// and heaps/<ML identifier>/log/A.db) from the heaps directory src into dst, and nothing else
+func copyIsabelleHeaps(src string, dst string, sessions map[string]bool) error {
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		name := strings.TrimSuffix(strings.TrimSuffix(info.Name(), ".gz"), ".db")
+		if !sessions[name] {
+			return nil
+		}
+
+		relative, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relative)
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		return copyFile(file, target)
+	})
+}
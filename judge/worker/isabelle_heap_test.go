@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCopyIsabelleHeaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := path.Join(dir, "src"), path.Join(dir, "dst")
+	files := []string{
+		"polyml-5.8_x86_64-linux/Provenian",
+		"polyml-5.8_x86_64-linux/Provenian_Problem",
+		"polyml-5.8_x86_64-linux/Provenian_Check",
+		"polyml-5.8_x86_64-linux/HOL",
+		"polyml-5.8_x86_64-linux/log/Provenian.db",
+		"polyml-5.8_x86_64-linux/log/Provenian.gz",
+		"polyml-5.8_x86_64-linux/log/Provenian_Check.db",
+	}
+	for _, file := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(src, file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(src, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := copyIsabelleHeaps(src, dst, map[string]bool{"Provenian": true, "Provenian_Problem": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = filepath.Walk(dst, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		relative, err := filepath.Rel(dst, file)
+		got = append(got, relative)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+
+	want := []string{
+		"polyml-5.8_x86_64-linux/Provenian",
+		"polyml-5.8_x86_64-linux/Provenian_Problem",
+		"polyml-5.8_x86_64-linux/log/Provenian.db",
+		"polyml-5.8_x86_64-linux/log/Provenian.gz",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+// Commands changing the syntax
+var isabelleSyntaxCommands = map[string]bool{
+	"notation":          true,
+	"no_notation":       true,
+	"type_notation":     true,
+	"no_type_notation":  true,
+	"syntax":            true,
+	"no_syntax":         true,
+	"translations":      true,
+	"no_translations":   true,
+	"parse_translation": true,
+	"print_translation": true,
+}
+
+// Mixfix annotations starting with these words, besides the ones with a template like ("_ ++ _")
+var isabelleMixfixWords = map[string]bool{
+	"infix":  true,
+	"infixl": true,
+	"infixr": true,
+	"binder": true,
+}
+
+// The check session writes the parents of the submitted theories with this function,
+// so that the imports are checked on the theories actually loaded. A parent is local if it is
+// submitted or one of the theories of ROOT (given by their long names), which can be imported always.
+const isabellePolicyML = `ML \<open>
+  fun provenian_policy_imports locals =
+    let
+      val local_theories = provenian_submitted @ map_filter (try Thy_Info.get_theory) locals
+      fun is_local thy = exists (fn theory => Context.eq_thy (theory, thy)) local_theories
+      fun parents thy =
+        map (fn parent =>
+          "import\t" ^ Context.theory_name thy ^ "\t" ^ Context.theory_name parent ^ "\t" ^
+            (if is_local parent then "local" else "global") ^ "\n") (Theory.parents_of thy)
+    in
+      File.append provenian_dependencies (implode (maps parents provenian_submitted))
+    end
+\<close>
+`
+
+type isabelleViolation struct {
+	Line int
+	Text string
+}
+
+// isabelleLocalTheories are the theories of the sessions in ROOT, which can be imported always.
+// They are named as the imports of the theories in the same session name them, by their base names,
+// and as the other sessions name them, qualified by the session (like Provenian.Submitted).
+func isabelleLocalTheories(ws Workspace) (map[string]bool, error) {
+	root, err := ioutil.ReadFile(path.Join(ws.Dir, "ROOT"))
+	if err != nil {
+		return nil, err
+	}
+
+	theories := map[string]bool{}
+	for _, session := range parseIsabelleRoot(string(root)) {
+		for _, theory := range session.Theories {
+			base := theory
+			if i := strings.LastIndexAny(theory, "./"); i >= 0 {
+				base = theory[i+1:]
+			}
+
+			theories[base] = true
+			theories[session.Name+"."+base] = true
+		}
+	}
+
+	return theories, nil
+}
+
+// isabelleImportAllowed tells whether the theory may be imported. The name is either as written
+// in the imports, or the long name of a loaded theory (like HOL.Main), which is only the base name
+// in some versions of Isabelle.
+func isabelleImportAllowed(policy problemmodel.Policy, localTheories map[string]bool, name string) bool {
+	if len(policy.Imports) == 0 || localTheories[name] {
+		return true
+	}
+
+	for _, allowed := range policy.Imports {
+		if name == allowed || strings.HasSuffix(name, "."+allowed) || strings.HasSuffix(allowed, "."+name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findIsabelleViolations lists the places where the source uses a construct the policy forbids
+func findIsabelleViolations(policy problemmodel.Policy, localTheories map[string]bool, source string) []isabelleViolation {
+	forbidden := map[string]bool{}
+	for _, command := range policy.ForbiddenCommands {
+		forbidden[command] = true
+	}
+
+	var tokens []isabelleToken
+	for _, token := range tokenizeIsabelle(source) {
+		if token.Kind != isabelleSpace && token.Kind != isabelleComment {
+			tokens = append(tokens, token)
+		}
+	}
+
+	var violations []isabelleViolation
+	imports := false
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if imports {
+			if token.Kind == isabelleWord && (token.Text == "begin" || token.Text == "keywords" || token.Text == "abbrevs") {
+				imports = false
+			} else if token.Kind == isabelleWord || token.Kind == isabelleString {
+				name, next := readIsabelleName(tokens, i)
+				if !isabelleImportAllowed(policy, localTheories, name) {
+					violations = append(violations, isabelleViolation{Line: token.Line, Text: "import of " + name})
+				}
+				i = next - 1
+				continue
+			}
+		}
+
+		if token.Kind == isabelleSymbol && token.Text == "(" && policy.ForbidSyntax && i+1 < len(tokens) {
+			next := tokens[i+1]
+			if next.Kind == isabelleString || next.Kind == isabelleCartouche || next.Kind == isabelleWord && isabelleMixfixWords[next.Text] {
+				violations = append(violations, isabelleViolation{Line: token.Line, Text: "mixfix annotation"})
+			}
+			continue
+		}
+
+		if token.Kind != isabelleWord {
+			continue
+		}
+
+		switch {
+		case token.Text == "imports":
+			imports = true
+		case policy.ForbidML && (isabelleMLCommands[token.Text] || isabelleMLMethods[token.Text]):
+			violations = append(violations, isabelleViolation{Line: token.Line, Text: token.Text})
+		case policy.ForbidDeclare && token.Text == "declare":
+			violations = append(violations, isabelleViolation{Line: token.Line, Text: token.Text})
+		case policy.ForbidSyntax && isabelleSyntaxCommands[token.Text]:
+			violations = append(violations, isabelleViolation{Line: token.Line, Text: token.Text})
+		case forbidden[token.Text]:
+			violations = append(violations, isabelleViolation{Line: token.Line, Text: token.Text})
+		}
+	}
+
+	return violations
+}
+
+// checkIsabellePolicy scans the submitted theories before the build
+func checkIsabellePolicy(ws Workspace) error {
+	localTheories, err := isabelleLocalTheories(ws)
+	if err != nil {
+		return err
+	}
+
+	var message strings.Builder
+	var diagnostics []model.Diagnostic
+	for _, filename := range isabelleSubmittedFiles(ws) {
+		source, err := ioutil.ReadFile(path.Join(ws.Dir, filename))
+		if err != nil {
+			return err
+		}
+
+		for _, violation := range findIsabelleViolations(ws.Problem.Policy, localTheories, string(source)) {
+			fmt.Fprintf(&message, "%s:%d: %s is not allowed\n", filename, violation.Line, violation.Text)
+			diagnostics = append(diagnostics, model.Diagnostic{
+				Theory:   strings.TrimSuffix(filename, ".thy"),
+				Line:     violation.Line,
+				Severity: model.SeverityError,
+				Message:  "Not allowed: " + violation.Text,
+			})
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return policyViolation{message: message.String(), diagnostics: diagnostics}
+	}
+
+	return nil
+}
+
+// checkIsabelleImports checks the parents of the submitted theories the check session wrote after the build
+func checkIsabelleImports(ws Workspace, result model.Result) (model.Result, error) {
+	if len(ws.Problem.Policy.Imports) == 0 {
+		return result, nil
+	}
+
+	dependencies, err := ioutil.ReadFile(path.Join(ws.Dir, isabelleCheckSession, isabelleDependenciesFile))
+	if err != nil {
+		return model.Result{}, err
+	}
+
+	var message strings.Builder
+	for _, line := range strings.Split(string(dependencies), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 || fields[0] != "import" || fields[3] == "local" {
+			continue
+		}
+
+		if !isabelleImportAllowed(ws.Problem.Policy, nil, fields[2]) {
+			fmt.Fprintf(&message, "%s imports %s, which is not allowed\n", fields[1], fields[2])
+		}
+	}
+
+	if message.Len() > 0 {
+		return model.PV(message.String()), nil
+	}
+
+	return result, nil
+}
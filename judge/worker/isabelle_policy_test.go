@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+)
+
+func TestIsabelleImportAllowed(t *testing.T) {
+	policy := problemmodel.Policy{Imports: []string{"Main", "HOL-Library.Multiset"}}
+	local := map[string]bool{"Analysis": true, "Provenian.Analysis": true}
+
+	cases := []struct {
+		policy problemmodel.Policy
+		name   string
+		want   bool
+	}{
+		{problemmodel.Policy{}, "HOL-Analysis.Analysis", true},
+		{policy, "Main", true},
+		{policy, "HOL.Main", true},
+		{policy, "HOL-Library.Multiset", true},
+		{policy, "Multiset", true},
+		{policy, "Analysis", true},
+		{policy, "Provenian.Analysis", true},
+		{policy, "HOL-Analysis.Analysis", false},
+		{policy, "Complex_Main", false},
+	}
+
+	for _, c := range cases {
+		if got := isabelleImportAllowed(c.policy, local, c.name); got != c.want {
+			t.Errorf("%+v %s: got %v, want %v", c.policy, c.name, got, c.want)
+		}
+	}
+}
+
+func TestFindIsabelleViolations(t *testing.T) {
+	local := map[string]bool{"Problem": true}
+
+	cases := []struct {
+		name   string
+		policy problemmodel.Policy
+		source string
+		want   []isabelleViolation
+	}{
+		{
+			name:   "no policy",
+			source: "theory Submitted imports \"HOL-Analysis.Analysis\" begin\nML ‹›\ndeclare [[simp_depth_limit = 1]]\nnotation f (\"F\")\nend",
+		},
+		{
+			name:   "imports",
+			policy: problemmodel.Policy{Imports: []string{"Main"}},
+			source: "theory Submitted\n  imports Main Problem\n    HOL-Analysis.Analysis \"HOL-Library.Multiset\"\nbegin\nend",
+			want:   []isabelleViolation{{Line: 3, Text: "import of HOL-Analysis.Analysis"}, {Line: 3, Text: "import of HOL-Library.Multiset"}},
+		},
+		{
+			name:   "imports end at keywords",
+			policy: problemmodel.Policy{Imports: []string{"Main"}},
+			source: "theory Submitted imports Main keywords \"foo\" :: thy_decl begin\nend",
+		},
+		{
+			name:   "ML",
+			policy: problemmodel.Policy{ForbidML: true},
+			source: "ML_file \"foo.ML\"\nlemma \"x = x\"\n  apply (tactic ‹all_tac›)\n  done\n(* ML ‹› *)\ntext ‹ML›",
+			want:   []isabelleViolation{{Line: 1, Text: "ML_file"}, {Line: 3, Text: "tactic"}},
+		},
+		{
+			name:   "declare",
+			policy: problemmodel.Policy{ForbidDeclare: true},
+			source: "declare [[show_types]]\nlemma foo [simp]: \"x = x\" by simp",
+			want:   []isabelleViolation{{Line: 1, Text: "declare"}},
+		},
+		{
+			name:   "syntax",
+			policy: problemmodel.Policy{ForbidSyntax: true},
+			source: "definition f :: \"nat ⇒ nat\" (\"F\") where \"f x = x\"\nabbreviation g (infixl \"++\" 65) where \"g ≡ f\"\nno_notation f\nlemma \"(f x) = x\" by simp",
+			want: []isabelleViolation{
+				{Line: 1, Text: "mixfix annotation"},
+				{Line: 2, Text: "mixfix annotation"},
+				{Line: 3, Text: "no_notation"},
+			},
+		},
+		{
+			name:   "forbidden commands",
+			policy: problemmodel.Policy{ForbiddenCommands: []string{"axiomatization", "nitpick"}},
+			source: "axiomatization where ax: \"False\"\nlemma \"x = x\"\n  nitpick\n  by simp",
+			want:   []isabelleViolation{{Line: 1, Text: "axiomatization"}, {Line: 3, Text: "nitpick"}},
+		},
+	}
+
+	for _, c := range cases {
+		if got := findIsabelleViolations(c.policy, local, c.source); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
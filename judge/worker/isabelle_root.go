@@ -0,0 +1,193 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+)
+
+// isabelleSession is a session entry of a ROOT file
+type isabelleSession struct {
+	Name     string
+	Parent   string
+	Theories []string
+}
+
+// Keywords of the session entries in ROOT files
+var isabelleRootKeywords = map[string]bool{
+	"session":        true,
+	"in":             true,
+	"description":    true,
+	"options":        true,
+	"sessions":       true,
+	"theories":       true,
+	"document_files": true,
+	"files":          true,
+	"export_files":   true,
+}
+
+// parseIsabelleRoot reads the names, the parents and the theories of the sessions in a ROOT file.
+// Everything else in the entries is skipped.
+func parseIsabelleRoot(root string) []isabelleSession {
+	var tokens []isabelleToken
+	for _, token := range tokenizeIsabelle(root) {
+		if token.Kind != isabelleSpace && token.Kind != isabelleComment {
+			tokens = append(tokens, token)
+		}
+	}
+
+	var sessions []isabelleSession
+	var session *isabelleSession
+	section := ""
+	depth := 0
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if token.Kind == isabelleSymbol && (token.Text == "(" || token.Text == "[") {
+			depth++
+			continue
+		}
+		if token.Kind == isabelleSymbol && (token.Text == ")" || token.Text == "]") {
+			depth--
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+
+		if token.Kind == isabelleWord && isabelleRootKeywords[token.Text] {
+			section = token.Text
+			if section == "session" {
+				name, next := readIsabelleName(tokens, i+1)
+				sessions = append(sessions, isabelleSession{Name: name})
+				session = &sessions[len(sessions)-1]
+				i = next - 1
+			}
+			continue
+		}
+		if session == nil {
+			continue
+		}
+
+		if token.Kind == isabelleSymbol && token.Text == "=" && session.Parent == "" {
+			name, next := readIsabelleName(tokens, i+1)
+			if next < len(tokens) && tokens[next].Text == "+" {
+				session.Parent = name
+				i = next
+			}
+			continue
+		}
+
+		if section == "theories" && (token.Kind == isabelleWord || token.Kind == isabelleString) {
+			name, next := readIsabelleName(tokens, i)
+			session.Theories = append(session.Theories, name)
+			i = next - 1
+		}
+	}
+
+	return sessions
+}
+
+// readIsabelleName reads a name starting at tokens[i], which is a string or words joined by "-"
+// like HOL-Analysis, and returns the index of the token after it
+func readIsabelleName(tokens []isabelleToken, i int) (string, int) {
+	if i >= len(tokens) {
+		return "", i
+	}
+	if tokens[i].Kind == isabelleString {
+		return tokens[i].Content(), i + 1
+	}
+
+	if tokens[i].Kind != isabelleWord {
+		return "", i
+	}
+
+	name := tokens[i].Text
+	i++
+	for i+1 < len(tokens) && tokens[i].Text == "-" && tokens[i+1].Kind == isabelleWord {
+		name += "-" + tokens[i+1].Text
+		i += 2
+	}
+
+	return name, i
+}
+
+// isabelleSubmissionSession returns the session having the submitted theory
+func isabelleSubmissionSession(sessions []isabelleSession) (isabelleSession, bool) {
+	theory := strings.TrimSuffix(isabelleSubmissionFile, ".thy")
+
+	for _, session := range sessions {
+		for _, name := range session.Theories {
+			if name == theory || strings.HasSuffix(name, "/"+theory) || strings.HasSuffix(name, "."+theory) {
+				return session, true
+			}
+		}
+	}
+
+	return isabelleSession{}, false
+}
+
+// The session generated for the submissions, when the problem does not configure it
+const isabelleDefaultSession = "Provenian"
+const isabelleDefaultParent = "HOL"
+
+// The attachment theories are built in this session (suffixed to the submission session),
+// so that its heap can be cached apart from the submissions
+const isabelleProblemSessionSuffix = "_Problem"
+
+// isabelleGeneratedRoot writes the ROOT file for the problem without its own one.
+// The attachment theories are made global, so that the submissions import them by their plain names.
+// The submission session records the dependencies of the proofs (see isabelleAuditML).
+func isabelleGeneratedRoot(config problemmodel.IsabelleSession, attachments []string, files []WorkspaceFile) (string, error) {
+	session := config.Name
+	if session == "" {
+		session = isabelleDefaultSession
+	}
+	parent := config.Parent
+	if parent == "" {
+		parent = isabelleDefaultParent
+	}
+
+	theories := config.Theories
+	if len(theories) == 0 {
+		for _, attachment := range attachments {
+			if strings.HasSuffix(attachment, ".thy") {
+				theories = append(theories, strings.TrimSuffix(attachment, ".thy"))
+			}
+		}
+	}
+
+	var submitted []string
+	for _, file := range files {
+		submitted = append(submitted, strings.TrimSuffix(file.Filename, ".thy"))
+	}
+	submitted = append(submitted, strings.TrimSuffix(isabelleSubmissionFile, ".thy"))
+
+	for _, name := range append(append([]string{session, parent}, theories...), submitted...) {
+		if name == "" || strings.ContainsAny(name, "\"\\") {
+			return "", errors.New("invalid name in the Isabelle session: " + name)
+		}
+	}
+
+	var root strings.Builder
+	if len(theories) > 0 {
+		problemSession := session + isabelleProblemSessionSuffix
+		fmt.Fprintf(&root, "session \"%s\" = \"%s\" +\n  theories\n", problemSession, parent)
+		for _, theory := range theories {
+			fmt.Fprintf(&root, "    \"%s\" (global)\n", theory)
+		}
+		fmt.Fprintln(&root)
+
+		parent = problemSession
+	}
+
+	fmt.Fprintf(&root, "session \"%s\" = \"%s\" +\n  options [record_proofs = 1]\n  theories\n", session, parent)
+	for _, theory := range submitted {
+		fmt.Fprintf(&root, "    \"%s\"\n", theory)
+	}
+
+	return root.String(), nil
+}
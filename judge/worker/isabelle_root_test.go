@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+)
+
+func TestParseIsabelleRoot(t *testing.T) {
+	cases := []struct {
+		name string
+		root string
+		want []isabelleSession
+	}{
+		{
+			name: "sessions",
+			root: `chapter Provenian
+
+session Problem (main) in "problem" = "HOL-Library" +
+  description "The problem"
+  options [timeout = 300, document = false]
+  sessions "HOL-Analysis"
+  theories
+    Definitions
+    "Lemmas" (global)
+
+(* the submission *)
+session Provenian = Problem +
+  theories [quick_and_dirty = false]
+    Submitted
+  document_files "root.tex"
+`,
+			want: []isabelleSession{
+				{Name: "Problem", Parent: "HOL-Library", Theories: []string{"Definitions", "Lemmas"}},
+				{Name: "Provenian", Parent: "Problem", Theories: []string{"Submitted"}},
+			},
+		},
+		{
+			name: "no parent",
+			root: "session Pure_Test =\n  theories Foo",
+			want: []isabelleSession{{Name: "Pure_Test", Theories: []string{"Foo"}}},
+		},
+	}
+
+	for _, c := range cases {
+		if got := parseIsabelleRoot(c.root); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsabelleSubmissionSession(t *testing.T) {
+	cases := []struct {
+		sessions []isabelleSession
+		want     string
+		ok       bool
+	}{
+		{[]isabelleSession{{Name: "A", Theories: []string{"Foo"}}, {Name: "B", Theories: []string{"Submitted"}}}, "B", true},
+		{[]isabelleSession{{Name: "A", Theories: []string{"src/Submitted"}}}, "A", true},
+		{[]isabelleSession{{Name: "A", Theories: []string{"NotSubmitted"}}}, "", false},
+	}
+
+	for _, c := range cases {
+		session, ok := isabelleSubmissionSession(c.sessions)
+		if session.Name != c.want || ok != c.ok {
+			t.Errorf("%+v: got %s %v, want %s %v", c.sessions, session.Name, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestIsabelleGeneratedRoot(t *testing.T) {
+	cases := []struct {
+		name        string
+		config      problemmodel.IsabelleSession
+		attachments []string
+		files       []WorkspaceFile
+		want        string
+		err         bool
+	}{
+		{
+			name: "default",
+			want: `session "Provenian" = "HOL" +
+  options [record_proofs = 1]
+  theories
+    "Submitted"
+`,
+		},
+		{
+			name:        "attachments",
+			attachments: []string{"Defs.thy", "data.txt"},
+			files:       []WorkspaceFile{{Filename: "Lemmas.thy"}},
+			want: `session "Provenian_Problem" = "HOL" +
+  theories
+    "Defs" (global)
+
+session "Provenian" = "Provenian_Problem" +
+  options [record_proofs = 1]
+  theories
+    "Lemmas"
+    "Submitted"
+`,
+		},
+		{
+			name:        "configured",
+			config:      problemmodel.IsabelleSession{Name: "Sorting", Parent: "HOL-Library", Theories: []string{"Base"}},
+			attachments: []string{"Base.thy", "Other.thy"},
+			want: `session "Sorting_Problem" = "HOL-Library" +
+  theories
+    "Base" (global)
+
+session "Sorting" = "Sorting_Problem" +
+  options [record_proofs = 1]
+  theories
+    "Submitted"
+`,
+		},
+		{
+			name:   "invalid name",
+			config: problemmodel.IsabelleSession{Parent: `HOL" + options [quick_and_dirty]`},
+			err:    true,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := isabelleGeneratedRoot(c.config, c.attachments, c.files)
+		if (err != nil) != c.err {
+			t.Errorf("%s: got error %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package worker
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type isabelleTokenKind int
+
+const (
+	isabelleWord isabelleTokenKind = iota
+	isabelleString
+	isabelleAltString
+	isabelleCartouche
+	isabelleVerbatim
+	isabelleComment
+	isabelleSpace
+	isabelleSymbol
+)
+
+// isabelleToken is a token of the Isabelle outer syntax.
+// Line is the 1-origin line number where the token starts.
+type isabelleToken struct {
+	Kind isabelleTokenKind
+	Text string
+	Line int
+}
+
+// Content returns the token text without its delimiters
+func (token isabelleToken) Content() string {
+	switch token.Kind {
+	case isabelleString, isabelleAltString:
+		return token.Text[1 : len(token.Text)-1]
+	case isabelleVerbatim:
+		return token.Text[2 : len(token.Text)-2]
+	case isabelleCartouche:
+		text := token.Text
+		for _, open := range []string{"‹", `\<open>`} {
+			if strings.HasPrefix(text, open) {
+				text = text[len(open):]
+			}
+		}
+		for _, close := range []string{"›", `\<close>`} {
+			if strings.HasSuffix(text, close) {
+				text = text[:len(text)-len(close)]
+			}
+		}
+
+		return text
+	}
+
+	return token.Text
+}
+
+func isIsabelleWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '\'' || r == '.'
+}
+
+// isabelleSymbolLength returns the length of a named symbol like \<comment>
+// at source[0], or 0 if there is none
+func isabelleSymbolLength(source string) int {
+	if !strings.HasPrefix(source, `\<`) {
+		return 0
+	}
+
+	for i := 2; i < len(source); i++ {
+		if source[i] == '>' {
+			return i + 1
+		}
+		if !(source[i] == '^' || source[i] < utf8.RuneSelf && isIsabelleWordRune(rune(source[i]))) {
+			return 0
+		}
+	}
+
+	return 0
+}
+
+// scanNested returns the length of the nested block starting at source[0].
+// Unterminated blocks extend to the end of the source.
+func scanNested(source string, opens []string, closes []string) int {
+	depth := 0
+	i := 0
+	for i < len(source) {
+		matched := false
+		for _, open := range opens {
+			if strings.HasPrefix(source[i:], open) {
+				depth++
+				i += len(open)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		for _, close := range closes {
+			if strings.HasPrefix(source[i:], close) {
+				depth--
+				i += len(close)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			if depth == 0 {
+				return i
+			}
+			continue
+		}
+
+		i++
+	}
+
+	return len(source)
+}
+
+// scanQuoted returns the length of the quoted text starting at source[0]
+func scanQuoted(source string, quote byte) int {
+	for i := 1; i < len(source); i++ {
+		if source[i] == '\\' {
+			i++
+		} else if source[i] == quote {
+			return i + 1
+		}
+	}
+
+	return len(source)
+}
+
+// tokenizeIsabelle splits a theory source into outer syntax tokens.
+// It is not a full parser, but it tells apart what is code and what is
+// a comment, a string or a cartouche, which is enough for scanning submissions.
+func tokenizeIsabelle(source string) []isabelleToken {
+	var tokens []isabelleToken
+
+	line := 1
+	for len(source) > 0 {
+		var kind isabelleTokenKind
+		var length int
+
+		r, size := utf8.DecodeRuneInString(source)
+		switch {
+		case strings.HasPrefix(source, "(*"):
+			kind, length = isabelleComment, scanNested(source, []string{"(*"}, []string{"*)"})
+		case strings.HasPrefix(source, "{*"):
+			kind, length = isabelleVerbatim, strings.Index(source, "*}")+2
+			if length == 1 {
+				length = len(source)
+			}
+		case strings.HasPrefix(source, "‹") || strings.HasPrefix(source, `\<open>`):
+			kind, length = isabelleCartouche, scanNested(source, []string{"‹", `\<open>`}, []string{"›", `\<close>`})
+		case r == '"':
+			kind, length = isabelleString, scanQuoted(source, '"')
+		case r == '`':
+			kind, length = isabelleAltString, scanQuoted(source, '`')
+		case isabelleSymbolLength(source) > 0:
+			kind, length = isabelleSymbol, isabelleSymbolLength(source)
+		case unicode.IsSpace(r):
+			kind, length = isabelleSpace, size
+			for length < len(source) {
+				next, nextSize := utf8.DecodeRuneInString(source[length:])
+				if !unicode.IsSpace(next) {
+					break
+				}
+				length += nextSize
+			}
+		case isIsabelleWordRune(r):
+			kind, length = isabelleWord, size
+			for length < len(source) {
+				next, nextSize := utf8.DecodeRuneInString(source[length:])
+				if !isIsabelleWordRune(next) {
+					break
+				}
+				length += nextSize
+			}
+		default:
+			kind, length = isabelleSymbol, size
+		}
+
+		text := source[:length]
+		tokens = append(tokens, isabelleToken{
+			Kind: kind,
+			Text: text,
+			Line: line,
+		})
+
+		line += strings.Count(text, "\n")
+		source = source[length:]
+	}
+
+	return tokens
+}
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeIsabelle(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   []isabelleToken
+	}{
+		{
+			name:   "words and strings",
+			source: `lemma foo: "x = x" by simp`,
+			want: []isabelleToken{
+				{Kind: isabelleWord, Text: "lemma", Line: 1},
+				{Kind: isabelleWord, Text: "foo", Line: 1},
+				{Kind: isabelleSymbol, Text: ":", Line: 1},
+				{Kind: isabelleString, Text: `"x = x"`, Line: 1},
+				{Kind: isabelleWord, Text: "by", Line: 1},
+				{Kind: isabelleWord, Text: "simp", Line: 1},
+			},
+		},
+		{
+			name:   "nested comments",
+			source: "(* a (* sorry *) b *) done",
+			want: []isabelleToken{
+				{Kind: isabelleComment, Text: "(* a (* sorry *) b *)", Line: 1},
+				{Kind: isabelleWord, Text: "done", Line: 1},
+			},
+		},
+		{
+			name:   "unterminated comment",
+			source: "(* sorry\ndone",
+			want: []isabelleToken{
+				{Kind: isabelleComment, Text: "(* sorry\ndone", Line: 1},
+			},
+		},
+		{
+			name:   "nested cartouches",
+			source: `text ‹a ‹b› c› ML \<open>x \<open>y\<close>\<close>`,
+			want: []isabelleToken{
+				{Kind: isabelleWord, Text: "text", Line: 1},
+				{Kind: isabelleCartouche, Text: "‹a ‹b› c›", Line: 1},
+				{Kind: isabelleWord, Text: "ML", Line: 1},
+				{Kind: isabelleCartouche, Text: `\<open>x \<open>y\<close>\<close>`, Line: 1},
+			},
+		},
+		{
+			name:   "verbatim",
+			source: "ML {* val x = 1 *}",
+			want: []isabelleToken{
+				{Kind: isabelleWord, Text: "ML", Line: 1},
+				{Kind: isabelleVerbatim, Text: "{* val x = 1 *}", Line: 1},
+			},
+		},
+		{
+			name:   "escaped quotes",
+			source: "\"a \\\" b\" `c`",
+			want: []isabelleToken{
+				{Kind: isabelleString, Text: "\"a \\\" b\"", Line: 1},
+				{Kind: isabelleAltString, Text: "`c`", Line: 1},
+			},
+		},
+		{
+			name:   "symbols",
+			source: `"A" \<Rightarrow> B`,
+			want: []isabelleToken{
+				{Kind: isabelleString, Text: `"A"`, Line: 1},
+				{Kind: isabelleSymbol, Text: `\<Rightarrow>`, Line: 1},
+				{Kind: isabelleWord, Text: "B", Line: 1},
+			},
+		},
+		{
+			name:   "lines",
+			source: "a\n\"b\nc\"\nd",
+			want: []isabelleToken{
+				{Kind: isabelleWord, Text: "a", Line: 1},
+				{Kind: isabelleString, Text: "\"b\nc\"", Line: 2},
+				{Kind: isabelleWord, Text: "d", Line: 4},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		var got []isabelleToken
+		for _, token := range tokenizeIsabelle(c.source) {
+			if token.Kind != isabelleSpace {
+				got = append(got, token)
+			}
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsabelleTokenContent(t *testing.T) {
+	cases := []struct {
+		token isabelleToken
+		want  string
+	}{
+		{isabelleToken{Kind: isabelleString, Text: `"x = x"`}, "x = x"},
+		{isabelleToken{Kind: isabelleAltString, Text: "`x`"}, "x"},
+		{isabelleToken{Kind: isabelleVerbatim, Text: "{* x *}"}, " x "},
+		{isabelleToken{Kind: isabelleCartouche, Text: "‹x›"}, "x"},
+		{isabelleToken{Kind: isabelleCartouche, Text: `\<open>x\<close>`}, "x"},
+		{isabelleToken{Kind: isabelleWord, Text: "x"}, "x"},
+	}
+
+	for _, c := range cases {
+		if got := c.token.Content(); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.token.Text, got, c.want)
+		}
+	}
+}
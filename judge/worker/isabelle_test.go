@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+func TestFindIsabelleCheats(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   []isabelleCheat
+	}{
+		{
+			name:   "sorry",
+			source: "lemma foo: \"x = x\"\n  sorry",
+			want:   []isabelleCheat{{Line: 2, Text: "sorry"}},
+		},
+		{
+			name:   "comments, strings and texts",
+			source: "(* sorry *)\ntext ‹sorry and Skip_Proof›\nlemma \"sorry = sorry\" oops",
+			want:   []isabelleCheat{{Line: 3, Text: "oops"}},
+		},
+		{
+			name:   "unsound modes",
+			source: "declare [[quick_and_dirty]]\ndeclare [[skip_proofs = true]]",
+			want:   []isabelleCheat{{Line: 1, Text: "quick_and_dirty"}, {Line: 2, Text: "skip_proofs"}},
+		},
+		{
+			name:   "ML commands",
+			source: "ML ‹\n  val thm = Skip_Proof.make_thm\n›\nsetup \\<open>Config.put_global quick_and_dirty true\\<close>",
+			want:   []isabelleCheat{{Line: 2, Text: "Skip_Proof"}, {Line: 4, Text: "quick_and_dirty"}},
+		},
+		{
+			name:   "ML environment",
+			source: "ML {* PolyML.Compiler.forgetValue \"x\" *}",
+			want:   []isabelleCheat{{Line: 1, Text: "PolyML"}},
+		},
+		{
+			name:   "tactic methods",
+			source: "lemma False\n  apply (tactic ‹cheat_tac @{context} 1›)\n  by (raw_tactic \"Skip_Proof.cheat_tac @{context} 1\")",
+			want:   []isabelleCheat{{Line: 2, Text: "cheat_tac"}, {Line: 3, Text: "Skip_Proof"}, {Line: 3, Text: "cheat_tac"}},
+		},
+		{
+			name:   "only the body of the ML command",
+			source: "ML_val ‹1›\nlemma \"Skip_Proof = x\" by simp",
+		},
+		{
+			name:   "tactic as a word",
+			source: "lemma tactic: \"x = x\" by (simp add: \"cheat_tac\")",
+		},
+	}
+
+	for _, c := range cases {
+		if got := findIsabelleCheats(c.source); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsabelleMLString(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"Submitted.foo", `"Submitted.foo"`},
+		{`x = "a"`, `"x = \"a\""`},
+		{`A \<longrightarrow> B`, `"A \092<longrightarrow> B"`},
+		{"a\\<close>\n", `"a\092<close>\010"`},
+		{"α", `"\206\177"`},
+	}
+
+	for _, c := range cases {
+		if got := isabelleMLString(c.text); got != c.want {
+			t.Errorf("%q: got %s, want %s", c.text, got, c.want)
+		}
+	}
+}
+
+func TestCheckIsabelleCheats(t *testing.T) {
+	ws := Workspace{Files: []WorkspaceFile{{Filename: "Lemmas.thy", Content: []byte("theory Lemmas imports Main begin\nlemma foo: False\n  oops\nend")}}}
+
+	if err := checkIsabelleCheats(ws, []byte("theory Submitted imports Lemmas begin\nend")); err == nil {
+		t.Fatalf("got no error")
+	} else if cheat, ok := err.(cheatDetected); !ok {
+		t.Errorf("got %v, want a cheat", err)
+	} else if want := []model.Diagnostic{{Theory: "Lemmas", Line: 3, Severity: model.SeverityError, Message: "Cheat: oops"}}; !reflect.DeepEqual(cheat.diagnostics, want) {
+		t.Errorf("got %+v, want %+v", cheat.diagnostics, want)
+	}
+
+	ws.Files = nil
+	if err := checkIsabelleCheats(ws, []byte("theory Submitted imports Main begin\nlemma \"x = x\" by simp\nend")); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
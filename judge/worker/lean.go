@@ -0,0 +1,355 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+var lakePath = os.Getenv("LAKE_PATH")
+
+// The submission is the root module of its own library,
+// the attachments are the submodules of the Problem library (`import Problem.Foo`)
+const (
+	leanSubmissionFile = "Submission.lean"
+	leanProblemLib     = "Problem"
+)
+
+const leanLakefile = `import Lake
+open Lake DSL
+
+package provenian
+
+@[default_target]
+lean_lib Submission
+`
+
+const leanProblemLakefile = `
+@[default_target]
+lean_lib Problem where
+  globs := #[.submodules ` + "`" + leanProblemLib + `]
+`
+
+// The file checking the goals, run by lean after the submission is built
+const leanCheckFile = "ProvenianCheck.lean"
+
+// The check writes the axioms here, one per line as "axiom<TAB>declaration<TAB>axiom<TAB>module"
+const leanAxiomsFile = "axioms"
+
+// The directories where lake puts the .olean files of the package, which depend on its version
+var leanLibDirs = []string{".lake/build/lib/lean", ".lake/build/lib"}
+
+// The axioms of the logic, which every proof may depend on
+var leanLogicAxioms = map[string]bool{
+	"propext":          true,
+	"Classical.choice": true,
+	"Quot.sound":       true,
+}
+
+// The check reads the declarations of the submission from the .olean files instead of importing it,
+// so that the syntax of the submission cannot change the statements and its code does not run.
+// The statements are elaborated with the problem only, and compared by Expr.eqv up to
+// alpha-conversion, so that a theorem merely definitionally equal to the goal is rejected.
+// The axioms are collected like collectAxioms does, through the declarations read from the files.
+const leanCheckHeader = `import Lean
+%sopen Lean Elab Command Term Meta
+
+structure ProvenianModules where
+  visited : NameSet := {}
+  constants : NameMap ConstantInfo := {}
+  modules : NameMap Name := {}
+
+partial def provenianRead (env : Environment) (module : Name) : StateT ProvenianModules IO Unit := do
+  if (env.getModuleIdx? module).isSome || (← get).visited.contains module then
+    return
+  modify fun s => { s with visited := s.visited.insert module }
+  let (data, _) ← readModuleData (← findOLean module)
+  for i in data.imports do
+    provenianRead env i.module
+  for c in data.constants do
+    modify fun s => { s with constants := s.constants.insert c.name c, modules := s.modules.insert c.name module }
+
+def provenianSubmission : TermElabM ProvenianModules := do
+  let (_, modules) ← (provenianRead (← getEnv) ` + "`" + `Submission).run {}
+  return modules
+
+partial def provenianAxioms (env : Environment) (modules : ProvenianModules) (name : Name) : StateM (NameSet × Array Name) Unit := do
+  if (← get).1.contains name then
+    return
+  modify fun (visited, axioms) => (visited.insert name, axioms)
+  let some info := modules.constants.find? name <|> env.find? name
+    | return
+  let collect (e : Expr) : StateM (NameSet × Array Name) Unit :=
+    e.getUsedConstants.forM (provenianAxioms env modules)
+  match info with
+  | .axiomInfo _ => modify fun (visited, axioms) => (visited, axioms.push name)
+  | .defnInfo v => do
+    collect v.type
+    collect v.value
+  | .thmInfo v => do
+    collect v.type
+    collect v.value
+  | .opaqueInfo v => do
+    collect v.type
+    collect v.value
+  | .quotInfo _ => pure ()
+  | .ctorInfo v => collect v.type
+  | .recInfo v => collect v.type
+  | .inductInfo v => do
+    collect v.type
+    v.ctors.forM (provenianAxioms env modules)
+
+def provenianRecord (modules : ProvenianModules) (name : Name) : TermElabM Unit := do
+  let (_, (_, axioms)) := (provenianAxioms (← getEnv) modules name).run ({}, #[])
+  let h ← IO.FS.Handle.mk "%s" .append
+  for ax in axioms do
+    let module ← match modules.modules.find? ax with
+      | some module => pure module
+      | none => do pure ((← findModuleOf? ax).getD .anonymous)
+    h.putStrLn s!"axiom\t{name}\t{ax}\t{module}"
+
+elab "#provenian_check " n:ident t:term : command => liftTermElabM do
+  let modules ← provenianSubmission
+  let some info := modules.constants.find? n.getId
+    | throwError "Unknown theorem: {n.getId}"
+  unless modules.modules.find? n.getId == some ` + "`" + `Submission do
+    throwError "Not declared by the submission: {n.getId}"
+  let expected ← instantiateMVars (← elabType t)
+  unless info.type.eqv expected do
+    throwError "Wrong statement: {n.getId} proves {info.type}"
+  provenianRecord modules n.getId
+
+elab "#provenian_audit" : command => liftTermElabM do
+  let modules ← provenianSubmission
+  for (name, module) in modules.modules do
+    if module == ` + "`" + `Submission then
+      provenianRecord modules name
+
+`
+
+type LeanVerifier struct {
+	lakePath string
+}
+
+func init() {
+	if lakePath == "" {
+		lakePath = "lake"
+	}
+
+	RegisterVerifier("lean4", LeanVerifier{lakePath: lakePath})
+	for language, path := range versionedPaths("LAKE_VERSIONS", "lean4") {
+		RegisterVerifier(language, LeanVerifier{lakePath: path})
+	}
+}
+
+// leanSources are the .lean attachments, which are the modules of the Problem library
+func leanSources(ws Workspace) []string {
+	var sources []string
+	for _, filename := range ws.Attachments {
+		if strings.HasSuffix(filename, ".lean") && filename != leanSubmissionFile && filename != leanCheckFile && filename != "lakefile.lean" {
+			sources = append(sources, filename)
+		}
+	}
+
+	return sources
+}
+
+// writeLakefile writes the lakefile, removing what lake caches of the previous one
+func writeLakefile(ws Workspace) error {
+	lakefile := leanLakefile
+	if len(leanSources(ws)) > 0 {
+		lakefile += leanProblemLakefile
+	}
+
+	for _, filename := range []string{"lakefile.olean", ".lake/lakefile.olean", "lake-manifest.json", "lakefile.lean"} {
+		if err := os.RemoveAll(path.Join(ws.Dir, filename)); err != nil {
+			return err
+		}
+	}
+
+	return writeFile(path.Join(ws.Dir, "lakefile.lean"), strings.NewReader(lakefile))
+}
+
+// Prepare turns the workspace into a lake project.
+// A lean-toolchain attachment is kept at the project root to pin the toolchain,
+// other .lean attachments are moved under the Problem directory.
+func (verifier LeanVerifier) Prepare(ws Workspace, code io.Reader) error {
+	if err := rejectFiles(ws); err != nil {
+		return err
+	}
+
+	if sources := leanSources(ws); len(sources) > 0 {
+		if err := os.MkdirAll(path.Join(ws.Dir, leanProblemLib), 0755); err != nil {
+			return err
+		}
+
+		for _, filename := range sources {
+			if err := os.Rename(path.Join(ws.Dir, filename), path.Join(ws.Dir, leanProblemLib, filename)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeLakefile(ws); err != nil {
+		return err
+	}
+
+	return writeFile(path.Join(ws.Dir, leanSubmissionFile), code)
+}
+
+// writeLeanCheck writes the check of the goals, or of all the declarations of the submission
+// if the problem has no goals
+func writeLeanCheck(ws Workspace) error {
+	var imports strings.Builder
+	for _, filename := range leanSources(ws) {
+		fmt.Fprintf(&imports, "import %s.%s\n", leanProblemLib, strings.TrimSuffix(filename, ".lean"))
+	}
+
+	axioms := path.Join(ws.Dir, leanAxiomsFile)
+
+	var check strings.Builder
+	fmt.Fprintf(&check, leanCheckHeader, imports.String(), axioms)
+	// Written even if nothing is found, so that the judge can tell that the check ran
+	fmt.Fprintf(&check, "#eval IO.FS.writeFile \"%s\" \"\"\n", axioms)
+	for _, goal := range ws.Goals {
+		fmt.Fprintf(&check, "#provenian_check %s (%s)\n", goal.Name, goal.Statement)
+	}
+	if len(ws.Goals) == 0 {
+		check.WriteString("#provenian_audit\n")
+	}
+
+	for _, filename := range []string{leanCheckFile, leanAxiomsFile} {
+		if err := os.RemoveAll(path.Join(ws.Dir, filename)); err != nil {
+			return err
+		}
+	}
+
+	return writeFile(path.Join(ws.Dir, leanCheckFile), strings.NewReader(check.String()))
+}
+
+// Run builds the default targets and then runs the check in a tree of its own, where the
+// submission never ran: the files the judge wrote, checked to be unchanged by the build,
+// with the Problem library built again and only Submission.olean taken from the build.
+// The check is written there after the build, so that the submission cannot tamper with it.
+func (verifier LeanVerifier) Run(ws Workspace) (Execution, error) {
+	files, err := takeSnapshot(ws.Dir)
+	if err != nil {
+		return Execution{}, err
+	}
+	// The check reads the submission from its .olean file only
+	delete(files, leanSubmissionFile)
+
+	execution, err := runCommand(ws, verifier.lakePath, "build")
+	if err != nil || execution.ExitCode != 0 {
+		return execution, err
+	}
+
+	checkDir, err := ioutil.TempDir(path.Dir(ws.Dir), path.Base(ws.Dir)+"-check-")
+	if err != nil {
+		return Execution{}, err
+	}
+	defer os.RemoveAll(checkDir)
+
+	checkWs := ws
+	checkWs.Dir = checkDir
+	if err := files.copyTo(ws.Dir, checkDir); err != nil {
+		return Execution{}, err
+	}
+	if err := writeLakefile(checkWs); err != nil {
+		return Execution{}, err
+	}
+
+	if len(leanSources(ws)) > 0 {
+		problem, err := runCommand(checkWs, verifier.lakePath, "build", leanProblemLib)
+		if err != nil {
+			return Execution{}, err
+		}
+		if problem.TimedOut || problem.MemoryExceeded || problem.Canceled {
+			problem.Log = execution.Log + problem.Log
+			return problem, nil
+		}
+		// The same library was built with the submission
+		if problem.ExitCode != 0 {
+			return Execution{}, fmt.Errorf("failed to build the problem library again:\n%s", problem.Log)
+		}
+	}
+
+	if err := copyLeanSubmission(ws, checkWs); err != nil {
+		return Execution{}, err
+	}
+	if err := writeLeanCheck(checkWs); err != nil {
+		return Execution{}, err
+	}
+
+	check, err := runCommand(checkWs, verifier.lakePath, "env", "lean", leanCheckFile)
+	if err != nil {
+		return Execution{}, err
+	}
+
+	check.Log = execution.Log + strings.Replace(check.Log, checkDir, ws.Dir, -1)
+	if check.ExitCode != 0 {
+		check.WrongStatement = true
+		return check, nil
+	}
+
+	check.Dependencies, err = readLeanAxioms(checkWs)
+	return check, err
+}
+
+// copyLeanSubmission copies Submission.olean built in the workspace into the same place of the check
+func copyLeanSubmission(ws Workspace, checkWs Workspace) error {
+	for _, dir := range leanLibDirs {
+		olean := path.Join(dir, strings.TrimSuffix(leanSubmissionFile, ".lean")+".olean")
+		if info, err := os.Lstat(path.Join(ws.Dir, olean)); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		if err := os.MkdirAll(path.Join(checkWs.Dir, dir), 0755); err != nil {
+			return err
+		}
+
+		return copyFile(path.Join(ws.Dir, olean), path.Join(checkWs.Dir, olean))
+	}
+
+	return errors.New("the build did not write " + strings.TrimSuffix(leanSubmissionFile, ".lean") + ".olean")
+}
+
+// readLeanAxioms reads the axioms written by the check, except the axioms of the logic
+// and of the problem. The check writes the file whenever it succeeds, so a missing one
+// is never read as no axioms.
+func readLeanAxioms(ws Workspace) ([]model.Dependency, error) {
+	axioms, err := ioutil.ReadFile(path.Join(ws.Dir, leanAxiomsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var dependencies []model.Dependency
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(axioms), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 || fields[0] != model.DependencyAxiom || seen[line] {
+			continue
+		}
+		seen[line] = true
+
+		if leanLogicAxioms[fields[2]] || fields[3] == leanProblemLib || strings.HasPrefix(fields[3], leanProblemLib+".") {
+			continue
+		}
+
+		dependencies = append(dependencies, model.Dependency{Kind: model.DependencyAxiom, Name: fields[2], Theorem: fields[1]})
+	}
+
+	return dependencies, nil
+}
+
+// Classify reports the declarations depending on sorry or on the axioms
+// the problem does not allow as CD
+func (verifier LeanVerifier) Classify(ws Workspace, execution Execution) (model.Result, error) {
+	return auditDependencies(ws, "lean4", classifyExecution(execution), execution.Dependencies), nil
+}
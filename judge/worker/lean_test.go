@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+func TestReadLeanAxioms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ws := Workspace{Dir: dir}
+
+	if _, err := readLeanAxioms(ws); err == nil {
+		t.Errorf("missing axioms: got no error")
+	}
+
+	cases := []struct {
+		name   string
+		axioms string
+		want   []model.Dependency
+	}{
+		{
+			name:   "nothing found",
+			axioms: "",
+		},
+		{
+			name:   "logic axioms",
+			axioms: "axiom\tfoo\tpropext\tInit.Core\naxiom\tfoo\tClassical.choice\tInit.Prelude\naxiom\tfoo\tQuot.sound\tInit.Core\n",
+		},
+		{
+			name:   "problem axioms",
+			axioms: "axiom\tfoo\tProblem.ax\tProblem\naxiom\tfoo\tax'\tProblem.Defs\n",
+		},
+		{
+			name:   "sorry and submitted axioms, once each",
+			axioms: "axiom\tfoo\tsorryAx\tInit.Prelude\naxiom\tbar\tmyAx\tSubmission\naxiom\tfoo\tsorryAx\tInit.Prelude\n",
+			want: []model.Dependency{
+				{Kind: model.DependencyAxiom, Name: "sorryAx", Theorem: "foo"},
+				{Kind: model.DependencyAxiom, Name: "myAx", Theorem: "bar"},
+			},
+		},
+		{
+			name:   "malformed lines",
+			axioms: "axiom\tfoo\nhello\naxiom\tfoo\tmyAx\tProblems\n",
+			want:   []model.Dependency{{Kind: model.DependencyAxiom, Name: "myAx", Theorem: "foo"}},
+		},
+	}
+
+	for _, c := range cases {
+		if err := ioutil.WriteFile(path.Join(dir, leanAxiomsFile), []byte(c.axioms), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readLeanAxioms(ws)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCopyLeanSubmission(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		links map[string]string
+		want  string
+	}{
+		{
+			name:  "lib",
+			files: map[string]string{".lake/build/lib/Submission.olean": "olean", ".lake/build/lib/Problem/Defs.olean": "fake"},
+			want:  ".lake/build/lib/Submission.olean",
+		},
+		{
+			name:  "lib/lean",
+			files: map[string]string{".lake/build/lib/lean/Submission.olean": "olean"},
+			want:  ".lake/build/lib/lean/Submission.olean",
+		},
+		{
+			name:  "missing",
+			files: map[string]string{".lake/build/Submission.olean": "olean"},
+		},
+		{
+			name:  "link",
+			files: map[string]string{"elsewhere.olean": "olean"},
+			links: map[string]string{".lake/build/lib/Submission.olean": "elsewhere.olean"},
+		},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "provenian-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		ws := Workspace{Dir: path.Join(dir, "ws")}
+		checkWs := Workspace{Dir: path.Join(dir, "check")}
+		for name, content := range c.files {
+			if err := os.MkdirAll(path.Dir(path.Join(ws.Dir, name)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path.Join(ws.Dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for name, target := range c.links {
+			if err := os.MkdirAll(path.Dir(path.Join(ws.Dir, name)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(path.Join(ws.Dir, target), path.Join(ws.Dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = copyLeanSubmission(ws, checkWs)
+		if (err == nil) != (c.want != "") {
+			t.Errorf("%s: got error %v", c.name, err)
+			continue
+		}
+		if c.want == "" {
+			continue
+		}
+
+		if content, err := ioutil.ReadFile(path.Join(checkWs.Dir, c.want)); err != nil || string(content) != "olean" {
+			t.Errorf("%s: got %q %v", c.name, content, err)
+		}
+		if _, err := os.Stat(path.Join(checkWs.Dir, ".lake/build/lib/Problem")); !os.IsNotExist(err) {
+			t.Errorf("%s: copied the problem library", c.name)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const memoryPollInterval = 500 * time.Millisecond
+
+// watchProcessGroup waits for the process group led by pid to finish, which is told by done.
+// The group is killed when the deadline passes, its resident memory goes over memoryLimit
+// or cancel is closed, and the reason is recorded in the execution.
+// In the sandbox pid is the init of a PID namespace, and killing it kills the whole namespace,
+// including the processes which left the group.
+// Zero values disable the limits.
+func watchProcessGroup(pid int, deadline time.Time, memoryLimit int64, cancel <-chan struct{}, done <-chan error, execution *Execution) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	ticker := time.NewTicker(memoryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-timeout:
+			execution.TimedOut = true
+			syscall.Kill(-pid, syscall.SIGKILL)
+		case <-cancel:
+			execution.Canceled = true
+			syscall.Kill(-pid, syscall.SIGKILL)
+			cancel = nil
+		case <-ticker.C:
+			if memoryLimit > 0 && processMemory(pid) > memoryLimit {
+				execution.MemoryExceeded = true
+				syscall.Kill(-pid, syscall.SIGKILL)
+			}
+		}
+	}
+}
+
+// processMemory returns the sum of the resident memory of the processes run by the command
+// started as pid, in bytes. They are the processes in its PID namespace in the sandbox,
+// which they cannot leave, and the processes in its group otherwise.
+// Processes exiting while /proc is read are skipped.
+func processMemory(pid int) int64 {
+	namespace := ""
+	if sandboxEnabled {
+		link, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/ns/pid")
+		if err != nil {
+			return 0
+		}
+		namespace = link
+	}
+
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+
+		stat, err := ioutil.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+
+		// The command name in the 2nd field may contain spaces, so split after it.
+		// The fields are then numbered from the 3rd one (state).
+		text := string(stat)
+		fields := strings.Fields(text[strings.LastIndex(text, ")")+1:])
+		if len(fields) < 22 {
+			continue
+		}
+
+		if namespace != "" {
+			if link, err := os.Readlink("/proc/" + entry.Name() + "/ns/pid"); err != nil || link != namespace {
+				continue
+			}
+		} else if group, err := strconv.Atoi(fields[2]); err != nil || group != pid {
+			continue
+		}
+
+		rss, err := strconv.ParseInt(fields[21], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		total += rss * int64(os.Getpagesize())
+	}
+
+	return total
+}
@@ -0,0 +1,379 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The verifier processes are run in a sandbox unless SANDBOX=off (for local development
+// on a machine where the judge cannot create namespaces).
+// The sandbox runs the command as SANDBOX_UID/SANDBOX_GID in new mount, network, pid, ipc
+// and uts namespaces, where the whole filesystem is read-only except the workspace and /tmp.
+var sandboxEnabled = os.Getenv("SANDBOX") != "off"
+var sandboxUID = getenvInt("SANDBOX_UID", 65534)
+var sandboxGID = getenvInt("SANDBOX_GID", 65534)
+
+// The cgroup under which every sandbox gets its own cgroup, if given. The memory limit is then
+// enforced by the kernel on all the processes of the sandbox instead of being polled.
+// It is a directory of the memory controller of cgroup v2 or v1, where the judge can create cgroups.
+var sandboxCgroup = os.Getenv("SANDBOX_CGROUP")
+
+// The judge re-executes itself with this argument to set up the sandbox from the inside
+const sandboxInitCommand = "sandbox-init"
+
+// Environment variables passed into the sandbox, everything else (including the AWS credentials) is dropped
+var sandboxEnvKeys = []string{"PATH", "LANG", "LC_ALL", "ELAN_HOME"}
+
+// sandboxHome is the home directory of the sandboxed user, relative to the workspace
+const sandboxHome = ".home"
+
+const prSetNoNewPrivs = 38
+
+// The init process of the sandbox reports the failures to set up the sandbox on this file
+// descriptor, so that they are not taken for the errors of the command
+const sandboxErrorsFd = 3
+
+// sandbox is what the judge keeps for a command run by sandboxCommand
+type sandbox struct {
+	// The cgroup limiting the memory, or ""
+	cgroup string
+	// The pipe of sandboxErrorsFd, whose write end the judge closes once the command starts
+	errors       *os.File
+	errorsWriter *os.File
+}
+
+// sandboxCommand returns the command run in the sandbox, with the sandbox which the caller
+// releases after the command finishes
+func sandboxCommand(ws Workspace, name string, args ...string) (*exec.Cmd, *sandbox, error) {
+	if !sandboxEnabled {
+		cmd := exec.Command(name, args...)
+		cmd.Dir = ws.Dir
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+		return cmd, &sandbox{}, nil
+	}
+
+	cgroup, err := createCgroup(ws.MemoryLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errors, errorsWriter, err := os.Pipe()
+	if err != nil {
+		removeCgroup(cgroup)
+		return nil, nil, err
+	}
+
+	cmd := exec.Command("/proc/self/exe", append([]string{sandboxInitCommand, ws.Dir, cgroup, name}, args...)...)
+	cmd.Dir = ws.Dir
+	cmd.ExtraFiles = []*os.File{errorsWriter}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+	}
+
+	cmd.Env = []string{"HOME=" + path.Join(ws.Dir, sandboxHome)}
+	for _, key := range sandboxEnvKeys {
+		if value, ok := os.LookupEnv(key); ok {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+
+	return cmd, &sandbox{cgroup: cgroup, errors: errors, errorsWriter: errorsWriter}, nil
+}
+
+// started is called once the command starts, so that reading the errors ends when the command exits
+func (sb *sandbox) started() {
+	if sb.errorsWriter != nil {
+		sb.errorsWriter.Close()
+		sb.errorsWriter = nil
+	}
+}
+
+// err returns the failure to set up the sandbox, after the command exits
+func (sb *sandbox) err() error {
+	if sb.errors == nil {
+		return nil
+	}
+
+	message, err := ioutil.ReadAll(sb.errors)
+	if err != nil {
+		return err
+	}
+	if len(message) > 0 {
+		return fmt.Errorf("sandbox: %s", strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+// limitsMemory tells whether the sandbox has a cgroup limiting its memory
+func (sb *sandbox) limitsMemory() bool {
+	return sb.cgroup != ""
+}
+
+// memoryExceeded tells whether the kernel killed some process for the memory limit of the cgroup
+func (sb *sandbox) memoryExceeded() bool {
+	return cgroupMemoryExceeded(sb.cgroup)
+}
+
+func (sb *sandbox) release() error {
+	sb.started()
+	if sb.errors != nil {
+		sb.errors.Close()
+	}
+
+	return removeCgroup(sb.cgroup)
+}
+
+type cgroupSetting struct {
+	file  string
+	value string
+	// The setting is skipped if the file is missing (like the swap limits without the swap accounting)
+	optional bool
+}
+
+// createCgroup creates the cgroup of a sandbox under SANDBOX_CGROUP, limited to memoryLimit bytes
+// without swap. In cgroup v2 memory.oom.group makes the OOM killer kill all the processes together.
+// It returns "" if there is no cgroup to create.
+func createCgroup(memoryLimit int64) (string, error) {
+	if sandboxCgroup == "" || memoryLimit <= 0 {
+		return "", nil
+	}
+
+	cgroup, err := ioutil.TempDir(sandboxCgroup, "sandbox-")
+	if err != nil {
+		return "", err
+	}
+
+	limit := strconv.FormatInt(memoryLimit, 10)
+	settings := []cgroupSetting{
+		{file: "memory.limit_in_bytes", value: limit},
+		{file: "memory.memsw.limit_in_bytes", value: limit, optional: true},
+	}
+	if _, err := os.Stat(path.Join(cgroup, "memory.max")); err == nil {
+		settings = []cgroupSetting{
+			{file: "memory.max", value: limit},
+			{file: "memory.swap.max", value: "0", optional: true},
+			{file: "memory.oom.group", value: "1"},
+		}
+	}
+
+	for _, setting := range settings {
+		if _, err := os.Stat(path.Join(cgroup, setting.file)); os.IsNotExist(err) && setting.optional {
+			continue
+		}
+
+		if err := ioutil.WriteFile(path.Join(cgroup, setting.file), []byte(setting.value), 0644); err != nil {
+			removeCgroup(cgroup)
+			return "", err
+		}
+	}
+
+	return cgroup, nil
+}
+
+// cgroupMemoryExceeded tells whether the OOM killer killed some process in the cgroup,
+// which is counted in memory.events in cgroup v2 and in memory.oom_control in v1
+func cgroupMemoryExceeded(cgroup string) bool {
+	if cgroup == "" {
+		return false
+	}
+
+	for _, file := range []string{"memory.events", "memory.oom_control"} {
+		events, err := ioutil.ReadFile(path.Join(cgroup, file))
+		if err != nil {
+			continue
+		}
+
+		for _, line := range strings.Split(string(events), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "oom_kill" && fields[1] != "0" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// removeCgroup removes the cgroup, waiting a while for the killed processes to leave it
+func removeCgroup(cgroup string) error {
+	if cgroup == "" {
+		return nil
+	}
+
+	var err error
+	for i := 0; i < 100; i++ {
+		if err = syscall.Rmdir(cgroup); err != syscall.EBUSY {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// sandboxInit runs as the init process of the namespaces created by sandboxCommand.
+// It joins the cgroup of the sandbox (unless it is ""), makes the filesystem read-only
+// except the workspace, then runs the command as the sandbox user and exits with its exit code.
+// The failures before the command starts are written to sandboxErrorsFd.
+func sandboxInit(args []string) {
+	syscall.CloseOnExec(sandboxErrorsFd)
+
+	if len(args) < 3 {
+		sandboxFail(fmt.Errorf("usage: %s WORKSPACE CGROUP COMMAND [ARGS...]", sandboxInitCommand))
+	}
+
+	dir, cgroup := args[0], args[1]
+	if err := setupSandbox(dir, cgroup); err != nil {
+		sandboxFail(err)
+	}
+
+	cmd := exec.Command(args[2], args[3:]...)
+	cmd.Dir = dir
+	cmd.Stdin = nil
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{
+			Uid:    uint32(sandboxUID),
+			Gid:    uint32(sandboxGID),
+			Groups: []uint32{},
+		},
+	}
+
+	if err := cmd.Start(); err != nil {
+		sandboxFail(err)
+	}
+	syscall.Close(sandboxErrorsFd)
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			fmt.Fprintln(os.Stderr, "sandbox:", err)
+			os.Exit(2)
+		}
+	}
+
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		os.Exit(128 + int(status.Signal()))
+	}
+
+	os.Exit(cmd.ProcessState.ExitCode())
+}
+
+// sandboxFail reports the failure to set up the sandbox to the judge, and exits
+func sandboxFail(err error) {
+	errors := os.NewFile(sandboxErrorsFd, "errors")
+	if _, writeErr := fmt.Fprintln(errors, err); writeErr != nil {
+		fmt.Fprintln(os.Stderr, "sandbox:", err)
+	}
+
+	os.Exit(2)
+}
+
+func setupSandbox(dir string, cgroup string) error {
+	// Before anything else, so that the command cannot start outside the cgroup
+	if cgroup != "" {
+		if err := ioutil.WriteFile(path.Join(cgroup, "cgroup.procs"), []byte("0"), 0644); err != nil {
+			return fmt.Errorf("join the cgroup: %v", err)
+		}
+	}
+
+	// Do not let the mounts below propagate to the judge
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return err
+	}
+
+	mountPoints, err := listMountPoints()
+	if err != nil {
+		return err
+	}
+
+	for _, mountPoint := range mountPoints {
+		if err := syscall.Mount("", mountPoint, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY|syscall.MS_NOSUID, ""); err != nil {
+			return fmt.Errorf("remount %s read-only: %v", mountPoint, err)
+		}
+	}
+
+	// Writable places, mounted on top of the read-only filesystem.
+	// The workspace is held open across the /tmp mount, which hides it if it lives under /tmp.
+	workspace, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer workspace.Close()
+
+	if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(fmt.Sprintf("/proc/self/fd/%d", workspace.Fd()), dir, "", syscall.MS_BIND, ""); err != nil {
+		return err
+	}
+	if err := syscall.Mount("", dir, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_NOSUID, ""); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Join(dir, sandboxHome), 0755); err != nil {
+		return err
+	}
+
+	if err := filepath.Walk(dir, func(filepath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(filepath, int(sandboxUID), int(sandboxGID))
+	}); err != nil {
+		return err
+	}
+
+	// Keep setuid binaries from giving the privileges back
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// listMountPoints reads the mount points of the current mount namespace
+func listMountPoints() ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mountPoints []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// The 5th field is the mount point, with spaces escaped as \040
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+
+		mountPoints = append(mountPoints, strings.Replace(fields[4], `\040`, " ", -1))
+	}
+
+	return mountPoints, scanner.Err()
+}
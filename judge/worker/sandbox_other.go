@@ -0,0 +1,50 @@
+//go:build !linux
+// +build !linux
+
+package worker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const sandboxInitCommand = "sandbox-init"
+
+const sandboxEnabled = false
+const sandboxHome = ".home"
+
+type sandbox struct{}
+
+// The sandbox depends on Linux namespaces, elsewhere the commands run unconfined
+func sandboxCommand(ws Workspace, name string, args ...string) (*exec.Cmd, *sandbox, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = ws.Dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	return cmd, &sandbox{}, nil
+}
+
+func (sb *sandbox) started() {}
+
+func (sb *sandbox) err() error {
+	return nil
+}
+
+func (sb *sandbox) limitsMemory() bool {
+	return false
+}
+
+func (sb *sandbox) memoryExceeded() bool {
+	return false
+}
+
+func (sb *sandbox) release() error {
+	return nil
+}
+
+func sandboxInit(args []string) {
+	fmt.Fprintln(os.Stderr, "sandbox: not supported on this platform")
+	os.Exit(2)
+}
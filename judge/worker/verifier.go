@@ -0,0 +1,323 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/functions/submit/model"
+)
+
+// Workspace is the directory where a submission to the problem is verified.
+// Problem attachments are downloaded into Dir before the verifier is called.
+// Files are the files submitted besides the main code, which the verifier puts into Dir.
+// Goals are the theorems of the problem the submission has to prove in its language.
+// The commands run for the submission are killed at Deadline, when they use more
+// than MemoryLimit bytes or when Cancel is closed.
+type Workspace struct {
+	Problem     problemmodel.Problem
+	Dir         string
+	Attachments []string
+	Files       []WorkspaceFile
+	Goals       []problemmodel.Goal
+	Deadline    time.Time
+	MemoryLimit int64
+	Cancel      <-chan struct{}
+}
+
+type WorkspaceFile struct {
+	Filename string
+	Content  []byte
+}
+
+// rejection is returned by Prepare for a submission the verifier does not accept,
+// which is reported as CE instead of an internal error
+type rejection struct {
+	message string
+}
+
+func (err rejection) Error() string {
+	return err.message
+}
+
+// policyViolation is returned by Prepare for a submission using a construct
+// the policy of the problem forbids, which is reported as PV
+type policyViolation struct {
+	message     string
+	diagnostics []model.Diagnostic
+}
+
+func (err policyViolation) Error() string {
+	return err.message
+}
+
+// cheatDetected is returned by Prepare for a submission skipping a proof, found in the code
+// as submitted before anything runs, which is reported as CD
+type cheatDetected struct {
+	message     string
+	diagnostics []model.Diagnostic
+}
+
+func (err cheatDetected) Error() string {
+	return err.message
+}
+
+// rejectFiles rejects the files submitted besides the main code, for the verifiers accepting only one file
+func rejectFiles(ws Workspace) error {
+	if len(ws.Files) > 0 {
+		return rejection{message: "This language accepts only one file"}
+	}
+
+	return nil
+}
+
+// Execution is the outcome of running a proof assistant
+type Execution struct {
+	ExitCode int
+	Log      string
+	// WrongStatement is set when the proof checks but does not prove the goals
+	WrongStatement bool
+	TimedOut       bool
+	MemoryExceeded bool
+	Canceled       bool
+	// Dependencies are the axioms the proofs depend on, for the verifiers finding them in Run
+	Dependencies []model.Dependency
+}
+
+// Verifier is a proof assistant backend of the judge
+type Verifier interface {
+	// Prepare puts the submitted code into the workspace
+	Prepare(ws Workspace, code io.Reader) error
+	// Run checks the workspace with the proof assistant
+	Run(ws Workspace) (Execution, error)
+	// Classify turns the execution into a judge result
+	Classify(ws Workspace, execution Execution) (model.Result, error)
+}
+
+var verifiers = map[string]Verifier{}
+
+// RegisterVerifier makes the verifier available for submissions in the language.
+// Backends call this from their init function.
+func RegisterVerifier(language string, verifier Verifier) {
+	if _, ok := verifiers[language]; ok {
+		panic("verifier already registered: " + language)
+	}
+
+	verifiers[language] = verifier
+}
+
+func LookupVerifier(language string) (Verifier, bool) {
+	verifier, ok := verifiers[language]
+	return verifier, ok
+}
+
+// versionedPaths reads the provers of the versioned languages from the environment variable,
+// given as language=path separated by commas (like isabelle2021=/opt/Isabelle2021/bin/isabelle).
+// The entries which are not the versions of the language are logged and skipped.
+func versionedPaths(key string, base string) map[string]string {
+	paths := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		pair := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(pair) != 2 || pair[1] == "" || problemmodel.BaseLanguage(pair[0]) != base || pair[0] == base {
+			log.Printf("skipping the invalid entry in %s: %s", key, entry)
+			continue
+		}
+
+		paths[pair[0]] = pair[1]
+	}
+
+	return paths
+}
+
+// classifyLimits reports the submissions killed for exceeding the limits,
+// before the verifier looks into the execution
+func classifyLimits(execution Execution) (model.Result, bool) {
+	if execution.TimedOut {
+		return model.TLE(execution.Log), true
+	}
+	if execution.MemoryExceeded {
+		return model.MLE(execution.Log), true
+	}
+
+	return model.Result{}, false
+}
+
+// classifyExecution is the default classification shared by the verifiers
+func classifyExecution(execution Execution) model.Result {
+	if execution.ExitCode == 0 {
+		return model.V(execution.Log)
+	}
+	if execution.WrongStatement {
+		return model.WS(execution.Log)
+	}
+
+	return model.CE(execution.Log)
+}
+
+// auditDependencies records the dependencies on the result, marking the ones the problem
+// allows by their exact names, and turns a verified result into CD if it does not allow some
+func auditDependencies(ws Workspace, language string, result model.Result, dependencies []model.Dependency) model.Result {
+	allowed := map[string]bool{}
+	for _, name := range ws.Problem.Axioms.Get(language) {
+		allowed[name] = true
+	}
+
+	var message strings.Builder
+	for index, dependency := range dependencies {
+		dependencies[index].Allowed = allowed[dependency.Name]
+		if !dependencies[index].Allowed {
+			fmt.Fprintf(&message, "%s depends on %s %s, which is not allowed\n", dependency.Theorem, dependency.Kind, dependency.Name)
+		}
+	}
+
+	if message.Len() > 0 && result.Code == model.V("").Code {
+		cheat := model.CD(message.String())
+		cheat.Diagnostics = result.Diagnostics
+		result = cheat
+	}
+
+	result.Dependencies = dependencies
+	return result
+}
+
+func writeFile(filepath string, body io.Reader) error {
+	file, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	if _, err := io.Copy(file, body); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// snapshot is the digests of the files the judge wrote into a workspace, by their paths relative to it
+type snapshot map[string][sha256.Size]byte
+
+// takeSnapshot records the regular files under dir, except the home of the sandbox
+func takeSnapshot(dir string) (snapshot, error) {
+	files := snapshot{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if relative == sandboxHome && info.IsDir() {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		files[relative] = sha256.Sum256(content)
+		return nil
+	})
+
+	return files, err
+}
+
+// copyTo copies the files of the snapshot from src into dst, failing if any of them
+// is no longer the regular file it was, so that the judge never reuses what a command changed
+func (files snapshot) copyTo(src string, dst string) error {
+	for relative, digest := range files {
+		info, err := os.Lstat(filepath.Join(src, relative))
+		if err != nil {
+			return fmt.Errorf("%s was removed from the workspace", relative)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s was replaced in the workspace", relative)
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(src, relative))
+		if err != nil {
+			return err
+		}
+		if sha256.Sum256(content) != digest {
+			return fmt.Errorf("%s was changed in the workspace", relative)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dst, relative)), 0755); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(dst, relative), bytes.NewReader(content)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runCommand runs the command in the sandbox of the workspace and collects stdout and
+// stderr into one log. The command runs in its own process group, so that the processes
+// it spawns are accounted and killed together with it.
+func runCommand(ws Workspace, name string, args ...string) (Execution, error) {
+	cmd, sandbox, err := sandboxCommand(ws, name, args...)
+	if err != nil {
+		return Execution{}, err
+	}
+	defer sandbox.release()
+
+	var log bytes.Buffer
+	cmd.Stdout = &log
+	cmd.Stderr = &log
+
+	if err := cmd.Start(); err != nil {
+		return Execution{}, err
+	}
+	sandbox.started()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	// The kernel enforces the memory limit of the cgroup, instead of the polling
+	memoryLimit := ws.MemoryLimit
+	if sandbox.limitsMemory() {
+		memoryLimit = 0
+	}
+
+	var execution Execution
+	err = watchProcessGroup(cmd.Process.Pid, ws.Deadline, memoryLimit, ws.Cancel, done, &execution)
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return Execution{}, err
+		}
+	}
+
+	// The command did not run, which is not its fault
+	if err := sandbox.err(); err != nil {
+		return Execution{}, err
+	}
+
+	execution.ExitCode = cmd.ProcessState.ExitCode()
+	execution.Log = log.String()
+	execution.MemoryExceeded = execution.MemoryExceeded || sandbox.memoryExceeded()
+
+	return execution, nil
+}
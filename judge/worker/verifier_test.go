@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(dir string) error
+		ok     bool
+	}{
+		{
+			name:   "unchanged",
+			change: func(dir string) error { return nil },
+			ok:     true,
+		},
+		{
+			name: "new files and the home",
+			change: func(dir string) error {
+				if err := ioutil.WriteFile(path.Join(dir, "new.thy"), nil, 0644); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(path.Join(dir, sandboxHome, "settings"), []byte("changed"), 0644)
+			},
+			ok: true,
+		},
+		{
+			name: "changed",
+			change: func(dir string) error {
+				return ioutil.WriteFile(path.Join(dir, "ROOT"), []byte("session Fake = HOL"), 0644)
+			},
+		},
+		{
+			name: "removed",
+			change: func(dir string) error {
+				return os.Remove(path.Join(dir, "sub", "Defs.thy"))
+			},
+		},
+		{
+			name: "replaced by a link",
+			change: func(dir string) error {
+				if err := os.Rename(path.Join(dir, "ROOT"), path.Join(dir, "ROOT.orig")); err != nil {
+					return err
+				}
+				return os.Symlink(path.Join(dir, "ROOT.orig"), path.Join(dir, "ROOT"))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "provenian-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		src, dst := path.Join(dir, "src"), path.Join(dir, "dst")
+		for _, dir := range []string{path.Join(src, "sub"), path.Join(src, sandboxHome)} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		files := map[string]string{"ROOT": "session Provenian = HOL", "sub/Defs.thy": "theory Defs", sandboxHome + "/settings": ""}
+		for name, content := range files {
+			if err := ioutil.WriteFile(path.Join(src, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		snapshot, err := takeSnapshot(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshot) != 2 {
+			t.Errorf("%s: got %d files in the snapshot, want 2", c.name, len(snapshot))
+		}
+
+		if err := c.change(src); err != nil {
+			t.Fatal(err)
+		}
+
+		err = snapshot.copyTo(src, dst)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: got error %v", c.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		for _, name := range []string{"ROOT", "sub/Defs.thy"} {
+			if content, err := ioutil.ReadFile(path.Join(dst, name)); err != nil || string(content) != files[name] {
+				t.Errorf("%s: got %q %v for %s", c.name, content, err, name)
+			}
+		}
+		if _, err := os.Stat(path.Join(dst, "new.thy")); !os.IsNotExist(err) {
+			t.Errorf("%s: copied a file outside the snapshot", c.name)
+		}
+	}
+}
+
+func TestVersionedPaths(t *testing.T) {
+	cases := []struct {
+		value string
+		want  map[string]string
+	}{
+		{"", map[string]string{}},
+		{
+			"isabelle2019=/opt/Isabelle2019/bin/isabelle, isabelle2021=/opt/Isabelle2021/bin/isabelle",
+			map[string]string{"isabelle2019": "/opt/Isabelle2019/bin/isabelle", "isabelle2021": "/opt/Isabelle2021/bin/isabelle"},
+		},
+		{
+			"isabelle=/opt/Isabelle/bin/isabelle,coq8.10=/usr/bin/coqc,isabellefoo=/bin/sh,isabelle2019,isabelle2020=,isabelle2021=/opt/Isabelle2021/bin/isabelle",
+			map[string]string{"isabelle2021": "/opt/Isabelle2021/bin/isabelle"},
+		},
+	}
+
+	for _, c := range cases {
+		os.Setenv("PROVENIAN_TEST_VERSIONS", c.value)
+		if got := versionedPaths("PROVENIAN_TEST_VERSIONS", "isabelle"); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %v, want %v", c.value, got, c.want)
+		}
+	}
+	os.Unsetenv("PROVENIAN_TEST_VERSIONS")
+}
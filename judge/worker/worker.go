@@ -0,0 +1,493 @@
+// Package worker receives submissions from the judge queue and verifies them
+// with the proof assistant of their language
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/functions/submit/model"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+var workspaceRoot = os.Getenv("WORKSPACE_ROOT")
+
+// Messages received more than this are moved to the dead-letter queue (if any) without verifying.
+// They are the submissions which kept the judge from finishing or reporting their results.
+var maxAttempts = getenvInt("JUDGE_MAX_ATTEMPTS", 3)
+
+// Received messages stay invisible for visibilityTimeout and it is extended
+// every visibilityExtension while the submission is verified
+const visibilityTimeout = 60 * time.Second
+const visibilityExtension = 30 * time.Second
+
+// Receive waits up to this for messages (long polling)
+const receiveWaitTime = 20 * time.Second
+
+// On SIGTERM the judge stops receiving and waits for the running submissions up to
+// this many seconds. The ones still running are then stopped and handed back to the queue.
+var shutdownTimeout = time.Duration(getenvInt("SHUTDOWN_TIMEOUT", 90)) * time.Second
+
+// errCanceled is returned for the submissions stopped by the shutdown
+var errCanceled = errors.New("canceled by shutdown")
+
+// Preparing the workspace, including the problem sessions the Isabelle verifier may build first,
+// does not count against the time limit, but the commands it runs are stopped after this many seconds
+var prepareTimeout = time.Duration(getenvInt("PREPARE_TIMEOUT", 3900)) * time.Second
+
+// Limits applied when the problem does not specify them
+var defaultTimeLimit = getenvInt("DEFAULT_TIME_LIMIT", 300)
+var defaultMemoryLimit = getenvInt("DEFAULT_MEMORY_LIMIT", 1024)
+
+func getenvInt(key string, fallback int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+// keepInvisible extends the visibility timeout of the message until stop is closed,
+// so that the message is not redelivered while a long proof is being checked
+func keepInvisible(queue storage.Queue, receiptHandle string, stop <-chan struct{}) {
+	ticker := time.NewTicker(visibilityExtension)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := queue.ChangeVisibility(receiptHandle, visibilityTimeout); err != nil {
+				log.Printf("failed to extend visibility: %v", err)
+			}
+		}
+	}
+}
+
+func readObject(blobs storage.BlobStore, key string) ([]byte, error) {
+	body, err := blobs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+// downloadObject writes the object of key to filepath
+func downloadObject(blobs storage.BlobStore, key string, filepath string) error {
+	body, err := blobs.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return writeFile(filepath, body)
+}
+
+// readProblem reads the published problem
+func readProblem(blobs storage.BlobStore, problemID string) (problemmodel.Problem, error) {
+	body, err := blobs.Get(problemID + ".json")
+	if err != nil {
+		return problemmodel.Problem{}, err
+	}
+	defer body.Close()
+
+	var problem problemmodel.Problem
+	if err := json.NewDecoder(body).Decode(&problem); err != nil {
+		return problemmodel.Problem{}, err
+	}
+
+	return problem, nil
+}
+
+// InitSandbox runs the command given in the arguments and exits, if the process is
+// re-executed by the judge as the init process of the sandbox.
+// It must be called at the beginning of main, before anything else is set up.
+func InitSandbox() {
+	if len(os.Args) > 1 && os.Args[1] == sandboxInitCommand {
+		sandboxInit(os.Args[2:])
+	}
+}
+
+// Start receives the submissions from queue and verifies them with workers in parallel
+// until SIGTERM or SIGINT. Every worker may use up to the memory limit of its problem.
+// deadLetter may be nil.
+func Start(queue storage.Queue, deadLetter storage.Queue, blobs storage.BlobStore, submissionTable storage.Table, workers int) {
+	// stopping is closed on SIGTERM, and canceled when the shutdown timeout passes
+	stopping := make(chan struct{})
+	canceled := make(chan struct{})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signals
+		log.Printf("shutting down")
+		close(stopping)
+
+		time.Sleep(shutdownTimeout)
+		log.Printf("stopping the running submissions")
+		close(canceled)
+	}()
+
+	// A slot is taken while a worker verifies a submission,
+	// so that no more messages than the idle workers are received
+	slots := make(chan struct{}, workers)
+	var running sync.WaitGroup
+
+receive:
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-stopping:
+			break receive
+		}
+
+		idle := 1
+	acquire:
+		for idle < workers {
+			select {
+			case slots <- struct{}{}:
+				idle++
+			default:
+				break acquire
+			}
+		}
+
+		messages, err := queue.Receive(idle, receiveWaitTime, visibilityTimeout)
+		if err != nil {
+			log.Printf("failed to receive: %v", err)
+		}
+
+		for i := len(messages); i < idle; i++ {
+			<-slots
+		}
+
+		select {
+		case <-stopping:
+			// Received during the shutdown, let other instances take them at once
+			for _, message := range messages {
+				if err := queue.ChangeVisibility(message.ReceiptHandle, 0); err != nil {
+					log.Printf("failed to hand back %s: %v", message.Body, err)
+				}
+			}
+			break receive
+		default:
+		}
+
+		for _, message := range messages {
+			running.Add(1)
+			go func(message storage.Message) {
+				defer running.Done()
+				defer func() { <-slots }()
+
+				handleMessage(queue, deadLetter, blobs, submissionTable, message, canceled)
+			}(message)
+		}
+
+		if err != nil {
+			time.Sleep(15 * time.Second)
+		}
+	}
+
+	running.Wait()
+	log.Printf("shut down")
+}
+
+// handleMessage verifies the submission of the message and deletes the message once the
+// result is written. When the judge fails to verify it, the submission gets an IE result.
+// The message is kept in the queue only if no result could be written at all, and is made
+// visible again if the verification is stopped by closing canceled.
+func handleMessage(queue storage.Queue, deadLetter storage.Queue, blobs storage.BlobStore, submissionTable storage.Table, message storage.Message, canceled <-chan struct{}) {
+	submissionID := message.Body
+
+	if count := message.ReceiveCount; count > maxAttempts {
+		log.Printf("giving up %s after %d attempts", submissionID, count)
+
+		if deadLetter != nil {
+			if err := deadLetter.Push(submissionID); err != nil {
+				log.Printf("failed to send %s to the dead-letter queue: %v", submissionID, err)
+				return
+			}
+		}
+
+		result := model.IE(fmt.Sprintf("The judge gave up after %d attempts", count))
+		if err := submissionTable.Update("id", submissionID, "result", result); err != nil {
+			log.Printf("failed to update %s: %v", submissionID, err)
+		}
+	} else {
+		stop := make(chan struct{})
+		go keepInvisible(queue, message.ReceiptHandle, stop)
+		err := runJob(submissionTable, blobs, submissionID, canceled)
+		close(stop)
+
+		if err == errCanceled {
+			log.Printf("handing back %s", submissionID)
+
+			if err := queue.ChangeVisibility(message.ReceiptHandle, 0); err != nil {
+				log.Printf("failed to hand back %s: %v", submissionID, err)
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("failed to judge %s: %+v", submissionID, err)
+
+			if err := submissionTable.Update("id", submissionID, "result", model.IE(err.Error())); err != nil {
+				log.Printf("failed to update %s: %v", submissionID, err)
+				return
+			}
+		}
+	}
+
+	if err := queue.Delete(message.ReceiptHandle); err != nil {
+		log.Printf("failed to delete %s: %v", submissionID, err)
+	}
+}
+
+// Run verifies the submission in the table as the workers do, and writes the result to the table.
+// Unlike the messages of the queue, the submission is not retried when the judge fails.
+func Run(submissionTable storage.Table, blobs storage.BlobStore, submissionID string) error {
+	return runJob(submissionTable, blobs, submissionID, nil)
+}
+
+// runJob runs execRunner, turning a panic into an error
+func runJob(submissionTable storage.Table, blobs storage.BlobStore, submissionID string, canceled <-chan struct{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return execRunner(submissionTable, blobs, submissionID, canceled)
+}
+
+// progress records the phases of the submission in the table as the judge goes through them
+type progress struct {
+	table        storage.Table
+	submissionID string
+	phases       []model.Phase
+}
+
+func (p *progress) enter(phase string) {
+	p.phases = append(p.phases, model.NewPhase(phase))
+	if err := p.table.Update("id", p.submissionID, "phases", p.phases); err != nil {
+		log.Printf("failed to update the phases of %s: %v", p.submissionID, err)
+	}
+}
+
+func execRunner(submissionTable storage.Table, blobs storage.BlobStore, submissionID string, canceled <-chan struct{}) error {
+	var submission model.Submission
+	if err := submissionTable.Get("id", submissionID, &submission); err != nil {
+		return err
+	}
+
+	progress := &progress{
+		table:        submissionTable,
+		submissionID: submission.ID,
+		phases:       submission.Phases,
+	}
+
+	result, err := verify(blobs, submission, canceled, progress.enter)
+	if err == errCanceled {
+		progress.enter(model.PhaseQueued)
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err = storeLog(blobs, submission, result)
+	if err != nil {
+		return err
+	}
+
+	progress.enter(model.PhaseFinished)
+	if err := submissionTable.Update("id", submission.ID, "result", result); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The result in the table keeps the tail of the log up to this many bytes
+// and at most maxDiagnostics diagnostics (and dependencies) with their messages (and names)
+// up to diagnosticLength bytes, to stay far below the item size limit of DynamoDB
+const logExcerptLength = 16 * 1024
+const maxDiagnostics = 100
+const diagnosticLength = 1024
+
+// truncate keeps the head of the text up to length bytes
+func truncate(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+
+	end := length
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+
+	return text[:end] + "..."
+}
+
+// storeLog uploads the message of the result as the full log of the submission
+// and shortens the result to be stored in the table
+func storeLog(blobs storage.BlobStore, submission model.Submission, result model.Result) (model.Result, error) {
+	if len(result.Diagnostics) > maxDiagnostics {
+		result.Diagnostics = result.Diagnostics[:maxDiagnostics]
+	}
+	if len(result.Dependencies) > maxDiagnostics {
+		result.Dependencies = result.Dependencies[:maxDiagnostics]
+	}
+	for index, diagnostic := range result.Diagnostics {
+		result.Diagnostics[index].Message = truncate(diagnostic.Message, diagnosticLength)
+	}
+	for index, dependency := range result.Dependencies {
+		result.Dependencies[index].Name = truncate(dependency.Name, diagnosticLength)
+		result.Dependencies[index].Theorem = truncate(dependency.Theorem, diagnosticLength)
+	}
+
+	if result.Message == "" {
+		return result, nil
+	}
+
+	if err := blobs.Put(submission.LogKey(), strings.NewReader(result.Message), "public, max-age=86400"); err != nil {
+		return model.Result{}, err
+	}
+	result.LogKey = submission.LogKey()
+
+	if len(result.Message) > logExcerptLength {
+		start := len(result.Message) - logExcerptLength
+		for start < len(result.Message) && !utf8.RuneStart(result.Message[start]) {
+			start++
+		}
+
+		result.Message = "... (see the full log)\n" + result.Message[start:]
+	}
+
+	return result, nil
+}
+
+// verify verifies the submission, calling enter at the beginning of each phase
+func verify(blobs storage.BlobStore, submission model.Submission, canceled <-chan struct{}, enter func(phase string)) (model.Result, error) {
+	enter(model.PhaseFetching)
+
+	verifier, ok := LookupVerifier(submission.Language)
+	if !ok {
+		return model.CE("Unsupported language: " + submission.Language), nil
+	}
+
+	problem, err := readProblem(blobs, submission.ProblemID)
+	if err != nil {
+		return model.Result{}, err
+	}
+	if !problem.Supports(submission.Language) {
+		return model.CE("This problem does not support " + submission.Language), nil
+	}
+
+	timeLimit := problem.Limits.Time
+	if timeLimit == 0 {
+		timeLimit = defaultTimeLimit
+	}
+	memoryLimit := problem.Limits.Memory
+	if memoryLimit == 0 {
+		memoryLimit = defaultMemoryLimit
+	}
+
+	dir, err := ioutil.TempDir(workspaceRoot, "submission-")
+	if err != nil {
+		return model.Result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	ws := Workspace{
+		Problem:     problem,
+		Dir:         dir,
+		Goals:       problem.Goals.Get(submission.Language),
+		MemoryLimit: memoryLimit * 1024 * 1024,
+		Cancel:      canceled,
+	}
+
+	// Download asset files
+	keys, err := blobs.List(submission.ProblemID + "/" + problemmodel.BaseLanguage(submission.Language) + "/")
+	if err != nil {
+		return model.Result{}, err
+	}
+
+	for _, key := range keys {
+		filename := path.Base(key)
+		if err := downloadObject(blobs, key, path.Join(ws.Dir, filename)); err != nil {
+			return model.Result{}, err
+		}
+
+		ws.Attachments = append(ws.Attachments, filename)
+	}
+
+	for _, file := range submission.Files {
+		content, err := readObject(blobs, file.Code)
+		if err != nil {
+			return model.Result{}, err
+		}
+
+		ws.Files = append(ws.Files, WorkspaceFile{Filename: file.Filename, Content: content})
+	}
+
+	// Save submission file
+	code, err := blobs.Get(submission.Code)
+	if err != nil {
+		return model.Result{}, err
+	}
+	defer code.Close()
+
+	ws.Deadline = time.Now().Add(prepareTimeout)
+	if err := verifier.Prepare(ws, code); err != nil {
+		if err, ok := err.(rejection); ok {
+			return model.CE(err.message), nil
+		}
+		if err, ok := err.(policyViolation); ok {
+			result := model.PV(err.message)
+			result.Diagnostics = err.diagnostics
+			return result, nil
+		}
+		if err, ok := err.(cheatDetected); ok {
+			result := model.CD(err.message)
+			result.Diagnostics = err.diagnostics
+			return result, nil
+		}
+
+		return model.Result{}, err
+	}
+
+	// Run verification process
+	enter(model.PhaseBuilding)
+	ws.Deadline = time.Now().Add(time.Duration(timeLimit) * time.Second)
+	execution, err := verifier.Run(ws)
+	if err != nil {
+		return model.Result{}, err
+	}
+
+	if execution.Canceled {
+		return model.Result{}, errCanceled
+	}
+
+	enter(model.PhaseChecking)
+	if result, ok := classifyLimits(execution); ok {
+		return result, nil
+	}
+
+	return verifier.Classify(ws, execution)
+}
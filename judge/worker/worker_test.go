@@ -0,0 +1,344 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	problemmodel "github.com/myuon/provenian/api/functions/problem/model"
+	"github.com/myuon/provenian/api/functions/submit/model"
+	"github.com/myuon/provenian/api/lib/storage"
+)
+
+// fakeVerifier runs the functions instead of a prover
+type fakeVerifier struct {
+	prepare func(ws Workspace) error
+	run     func(ws Workspace) (Execution, error)
+}
+
+func (verifier fakeVerifier) Prepare(ws Workspace, code io.Reader) error {
+	if verifier.prepare == nil {
+		return nil
+	}
+
+	return verifier.prepare(ws)
+}
+
+func (verifier fakeVerifier) Run(ws Workspace) (Execution, error) {
+	if verifier.run == nil {
+		return Execution{}, nil
+	}
+
+	return verifier.run(ws)
+}
+
+func (verifier fakeVerifier) Classify(ws Workspace, execution Execution) (model.Result, error) {
+	return classifyExecution(execution), nil
+}
+
+// useFakeVerifier verifies the coq submissions with the verifier until restore is called
+func useFakeVerifier(verifier Verifier) (restore func()) {
+	coq := verifiers["coq"]
+	verifiers["coq"] = verifier
+
+	return func() { verifiers["coq"] = coq }
+}
+
+// newTestStore publishes a coq problem with the time limit of 10 seconds,
+// and puts the submissions of the code to it
+func newTestStore(t *testing.T, dir string, submissionIDs ...string) (storage.BlobStore, storage.Table) {
+	blobs := storage.NewDirBlobStore(path.Join(dir, "storage"))
+	problem, err := json.Marshal(problemmodel.Problem{
+		ID:     "problem",
+		Files:  problemmodel.LanguageFiles{Coq: []string{"Defs.v"}},
+		Limits: problemmodel.Limits{Time: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := map[string]string{"problem.json": string(problem), "problem/coq/Defs.v": "Definition x := 0.", "code": "Theorem"}
+	for key, body := range objects {
+		if err := blobs.Put(key, strings.NewReader(body), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table, err := storage.NewFileTable(path.Join(dir, "submit.json"), "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, submissionID := range submissionIDs {
+		submission := model.Submission{
+			ID:        submissionID,
+			ProblemID: "problem",
+			Language:  "coq",
+			Code:      "code",
+			Phases:    []model.Phase{model.NewPhase(model.PhaseQueued)},
+		}
+		if err := table.Put(submission); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return blobs, table
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blobs, _ := newTestStore(t, dir)
+
+	cases := []struct {
+		name       string
+		prepareErr error
+		execution  Execution
+		want       string
+		err        bool
+	}{
+		{name: "verified", want: model.V("").Code},
+		{name: "rejected", prepareErr: rejection{message: "rejected"}, want: model.CE("").Code},
+		{name: "policy violation", prepareErr: policyViolation{message: "forbidden"}, want: model.PV("").Code},
+		{name: "cheat", prepareErr: cheatDetected{message: "sorry"}, want: model.CD("").Code},
+		{name: "failed to prepare", prepareErr: errors.New("failed"), err: true},
+		{name: "timed out", execution: Execution{ExitCode: -1, TimedOut: true}, want: model.TLE("").Code},
+		{name: "canceled", execution: Execution{ExitCode: -1, Canceled: true}, err: true},
+	}
+
+	for _, c := range cases {
+		var prepareDeadline, runDeadline time.Time
+		restore := useFakeVerifier(fakeVerifier{
+			prepare: func(ws Workspace) error {
+				prepareDeadline = ws.Deadline
+				return c.prepareErr
+			},
+			run: func(ws Workspace) (Execution, error) {
+				runDeadline = ws.Deadline
+				return c.execution, nil
+			},
+		})
+
+		start := time.Now()
+		result, err := verify(blobs, model.Submission{ProblemID: "problem", Language: "coq", Code: "code"}, nil, func(string) {})
+		restore()
+		if (err != nil) != c.err {
+			t.Errorf("%s: got error %v", c.name, err)
+			continue
+		}
+		if err == nil && result.Code != c.want {
+			t.Errorf("%s: got %+v, want %s", c.name, result, c.want)
+		}
+
+		if prepareDeadline.Before(start.Add(prepareTimeout)) || prepareDeadline.After(time.Now().Add(prepareTimeout)) {
+			t.Errorf("%s: got the deadline %v for Prepare, want %v after the start", c.name, prepareDeadline, prepareTimeout)
+		}
+		if c.prepareErr == nil && (runDeadline.Before(start.Add(10*time.Second)) || runDeadline.After(time.Now().Add(10*time.Second))) {
+			t.Errorf("%s: got the deadline %v for Run, want the time limit after the start", c.name, runDeadline)
+		}
+	}
+}
+
+func TestParallelWorkspaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	submissionIDs := []string{"s1", "s2", "s3"}
+	blobs, table := newTestStore(t, dir, submissionIDs...)
+
+	// Every submission waits in Run until all of them are there
+	var mutex sync.Mutex
+	dirs := map[string]bool{}
+	entered := make(chan struct{}, len(submissionIDs))
+	release := make(chan struct{})
+	defer useFakeVerifier(fakeVerifier{
+		run: func(ws Workspace) (Execution, error) {
+			mutex.Lock()
+			dirs[ws.Dir] = true
+			mutex.Unlock()
+
+			if _, err := os.Stat(path.Join(ws.Dir, "Defs.v")); err != nil {
+				return Execution{}, err
+			}
+
+			entered <- struct{}{}
+			<-release
+			return Execution{}, nil
+		},
+	})()
+
+	var running sync.WaitGroup
+	errs := make(chan error, len(submissionIDs))
+	for _, submissionID := range submissionIDs {
+		running.Add(1)
+		go func(submissionID string) {
+			defer running.Done()
+			errs <- runJob(table, blobs, submissionID, nil)
+		}(submissionID)
+	}
+
+	for range submissionIDs {
+		select {
+		case <-entered:
+		case <-time.After(10 * time.Second):
+			t.Fatal("the submissions are not verified in parallel")
+		}
+	}
+	close(release)
+	running.Wait()
+
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if len(dirs) != len(submissionIDs) {
+		t.Errorf("got the workspaces %v, want one for each submission", dirs)
+	}
+	for dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("the workspace %s is left", dir)
+		}
+	}
+
+	for _, submissionID := range submissionIDs {
+		var submission model.Submission
+		if err := table.Get("id", submissionID, &submission); err != nil {
+			t.Fatal(err)
+		}
+		if submission.Result.Code != model.V("").Code {
+			t.Errorf("%s: got %+v", submissionID, submission.Result)
+		}
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	cases := []struct {
+		name     string
+		verifier fakeVerifier
+		// The times the message was received before
+		received     int
+		want         string
+		kept         bool
+		deadLettered bool
+	}{
+		{
+			name: "verified",
+			want: model.V("").Code,
+		},
+		{
+			name:     "not verified",
+			verifier: fakeVerifier{run: func(ws Workspace) (Execution, error) { return Execution{ExitCode: 1}, nil }},
+			want:     model.CE("").Code,
+		},
+		{
+			name:     "failed",
+			verifier: fakeVerifier{prepare: func(ws Workspace) error { return errors.New("failed") }},
+			want:     model.IE("").Code,
+		},
+		{
+			name:     "panicked",
+			verifier: fakeVerifier{run: func(ws Workspace) (Execution, error) { panic("broken") }},
+			want:     model.IE("").Code,
+		},
+		{
+			name:         "given up",
+			verifier:     fakeVerifier{run: func(ws Workspace) (Execution, error) { panic("run after giving up") }},
+			received:     int(maxAttempts),
+			want:         model.IE("").Code,
+			deadLettered: true,
+		},
+		{
+			name:     "stopped by the shutdown",
+			verifier: fakeVerifier{run: func(ws Workspace) (Execution, error) { return Execution{ExitCode: -1, Canceled: true}, nil }},
+			kept:     true,
+		},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "provenian-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		blobs, table := newTestStore(t, dir, "s1")
+		restore := useFakeVerifier(c.verifier)
+
+		queue, deadLetter := storage.NewMemoryQueue(), storage.NewMemoryQueue()
+		if err := queue.Push("s1"); err != nil {
+			t.Fatal(err)
+		}
+		messages, err := queue.Receive(1, 0, time.Minute)
+		if err != nil || len(messages) != 1 {
+			t.Fatal(messages, err)
+		}
+		messages[0].ReceiveCount += int64(c.received)
+
+		handleMessage(queue, deadLetter, blobs, table, messages[0], nil)
+		restore()
+
+		var submission model.Submission
+		if err := table.Get("id", "s1", &submission); err != nil {
+			t.Fatal(err)
+		}
+		if submission.Result.Code != c.want {
+			t.Errorf("%s: got %+v, want %s", c.name, submission.Result, c.want)
+		}
+		// Handed back as it was queued
+		if phase := submission.Phases[len(submission.Phases)-1].Name; c.kept && phase != model.PhaseQueued {
+			t.Errorf("%s: got the phase %s, want %s", c.name, phase, model.PhaseQueued)
+		}
+
+		// Only the message handed back is visible again at once, and the others are deleted
+		if left, err := queue.Receive(1, 0, time.Minute); err != nil || (len(left) > 0) != c.kept {
+			t.Errorf("%s: got the messages %+v %v visible in the queue", c.name, left, err)
+		}
+		if err := queue.ChangeVisibility(messages[0].ReceiptHandle, 0); !c.kept && err != storage.ErrNotFound {
+			t.Errorf("%s: the message is not deleted", c.name)
+		}
+		if dead, err := deadLetter.Receive(1, 0, 0); err != nil || (len(dead) > 0) != c.deadLettered {
+			t.Errorf("%s: got the messages %+v %v in the dead-letter queue", c.name, dead, err)
+		}
+	}
+}
+
+func TestBuildHeapsDeadline(t *testing.T) {
+	if sandboxEnabled {
+		t.Skip("runs the fake prover only with SANDBOX=off")
+	}
+
+	dir, err := ioutil.TempDir("", "provenian-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	isabelle := path.Join(dir, "isabelle")
+	if err := ioutil.WriteFile(isabelle, []byte("#!/bin/sh\nsleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	ws := Workspace{Dir: dir, Deadline: start.Add(200 * time.Millisecond)}
+	if err := (IsabelleVerifier{isabellePath: isabelle}).buildHeaps(ws, isabelleEnvironment{}, "Provenian", path.Join(dir, "cached")); err == nil {
+		t.Errorf("got no error")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("the build ran for %v past the deadline of Prepare", elapsed)
+	}
+}
@@ -5,8 +5,29 @@ export interface ProblemDetail {
   files: { [language: string]: string[] };
   id: string;
   languages: string[];
+  template?: { [language: string]: string };
   title: string;
   updated_at: number;
   version: string;
   writer: string;
 }
+
+export interface Diagnostic {
+  theory: string;
+  line: number;
+  column: number;
+  severity: "error" | "warning";
+  message: string;
+}
+
+export interface Phase {
+  name: "queued" | "fetching" | "building" | "checking" | "finished";
+  started_at: number;
+}
+
+export interface Dependency {
+  kind: "axiom" | "oracle";
+  name: string;
+  theorem: string;
+  allowed: boolean;
+}
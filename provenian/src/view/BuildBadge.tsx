@@ -4,6 +4,8 @@ import { Label, Popup } from "semantic-ui-react";
 const badgeColor = (status: string) => {
   if (status === "WJ") {
     return "grey";
+  } else if (status === "JG") {
+    return "blue";
   } else if (status === "V") {
     return "green";
   } else if (status === "CE") {
@@ -1,8 +1,9 @@
 import React, { useEffect, useState } from "react";
-import { Header } from "semantic-ui-react";
+import { Header, Message, Table } from "semantic-ui-react";
 import axios from "axios";
 import { RouteComponentProps } from "react-router";
 import BuildBadge from "./BuildBadge";
+import { Dependency, Diagnostic, Phase } from "../types";
 
 const sleep = (time: number) => {
   return new Promise((resolve, reject) => {
@@ -12,15 +13,35 @@ const sleep = (time: number) => {
   });
 };
 
+const phaseLabel = (name: string) => {
+  if (name === "queued") {
+    return "待機中";
+  } else if (name === "fetching") {
+    return "準備中";
+  } else if (name === "building") {
+    return "ビルド中";
+  } else if (name === "checking") {
+    return "検査中";
+  } else if (name === "finished") {
+    return "完了";
+  } else {
+    return name;
+  }
+};
+
 const Submission: React.FC<
   RouteComponentProps<{ submissionId: string }>
 > = props => {
   const [judgeResult, setJudgeResult] = useState({} as any);
   const [source, setSource] = useState("");
+  const [phases, setPhases] = useState([] as Phase[]);
 
   useEffect(() => {
+    let events: EventSource | undefined;
+    let socket: WebSocket | undefined;
+
     (async () => {
-      const { code, result } = (await axios.get(
+      const { code, result, phases } = (await axios.get(
         `${process.env.REACT_APP_API_ENDPOINT}/submissions/${
           props.match.params.submissionId
         }`
@@ -31,17 +52,74 @@ const Submission: React.FC<
           setSource(result.data);
         });
       setJudgeResult(result);
+      setPhases(phases || []);
+
+      // Pushed by the WebSocket API in production instead of polling
+      if (!result.is_finished && process.env.REACT_APP_EVENT_SOCKET) {
+        socket = new WebSocket(
+          `${process.env.REACT_APP_EVENT_SOCKET}?submission_id=${
+            props.match.params.submissionId
+          }`
+        );
+        socket.onopen = async () => {
+          // The transitions before the connection are not pushed
+          const { result, phases } = (await axios.get(
+            `${process.env.REACT_APP_API_ENDPOINT}/submissions/${
+              props.match.params.submissionId
+            }`
+          )).data;
+          setJudgeResult(result);
+          setPhases(phases || []);
+
+          if (result.is_finished && socket) {
+            socket.close();
+          }
+        };
+        socket.onmessage = (message: MessageEvent) => {
+          const { result, phases } = JSON.parse(message.data);
+          setJudgeResult(result);
+          setPhases(phases || []);
+
+          if (result.is_finished && socket) {
+            socket.close();
+          }
+        };
+        return;
+      }
+
+      // Pushed by the server instead of polling, if it streams the events
+      if (
+        !result.is_finished &&
+        process.env.REACT_APP_EVENT_STREAM === "true"
+      ) {
+        events = new EventSource(
+          `${process.env.REACT_APP_API_ENDPOINT}/submissions/${
+            props.match.params.submissionId
+          }/events`
+        );
+        events.addEventListener("submission", (message: any) => {
+          const { result, phases } = JSON.parse(message.data);
+          setJudgeResult(result);
+          setPhases(phases || []);
+
+          if (result.is_finished && events) {
+            events.close();
+          }
+        });
+        return;
+      }
 
       let count = 0;
       while (!result.is_finished && count < 100) {
-        const { result } = (await axios.get(
+        const { result, phases } = (await axios.get(
           `${process.env.REACT_APP_API_ENDPOINT}/submissions/${
             props.match.params.submissionId
           }`
         )).data;
 
+        setJudgeResult(result);
+        setPhases(phases || []);
         if (result.is_finished) {
-          setJudgeResult(result);
           break;
         }
 
@@ -49,6 +127,15 @@ const Submission: React.FC<
         count += 1;
       }
     })();
+
+    return () => {
+      if (events) {
+        events.close();
+      }
+      if (socket) {
+        socket.close();
+      }
+    };
   }, [props.match.params.submissionId]);
 
   return (
@@ -62,7 +149,81 @@ const Submission: React.FC<
 
       <Header as="h2">結果</Header>
 
+      {phases.length > 0 && (
+        <Table compact collapsing>
+          <Table.Body>
+            {phases.map((phase, index) => (
+              <Table.Row key={index}>
+                <Table.Cell>{phaseLabel(phase.name)}</Table.Cell>
+                <Table.Cell>
+                  {new Date(phase.started_at).toLocaleTimeString()}
+                </Table.Cell>
+                <Table.Cell>
+                  {index + 1 < phases.length
+                    ? `${(phases[index + 1].started_at - phase.started_at) /
+                        1000}s`
+                    : ""}
+                </Table.Cell>
+              </Table.Row>
+            ))}
+          </Table.Body>
+        </Table>
+      )}
+
+      {judgeResult.diagnostics && (
+        <>
+          <Header as="h4">診断</Header>
+          {judgeResult.diagnostics.map(
+            (diagnostic: Diagnostic, index: number) => (
+              <Message
+                key={index}
+                size="small"
+                error={diagnostic.severity === "error"}
+                warning={diagnostic.severity === "warning"}
+              >
+                <Message.Header>
+                  {diagnostic.theory}
+                  {diagnostic.line > 0 && `:${diagnostic.line}`}
+                  {diagnostic.column > 0 && `:${diagnostic.column}`}
+                </Message.Header>
+                <pre>{diagnostic.message}</pre>
+              </Message>
+            )
+          )}
+        </>
+      )}
+
+      {judgeResult.dependencies && (
+        <>
+          <Header as="h4">公理と Oracle</Header>
+          <Table compact collapsing>
+            <Table.Body>
+              {judgeResult.dependencies.map(
+                (dependency: Dependency, index: number) => (
+                  <Table.Row key={index} negative={!dependency.allowed}>
+                    <Table.Cell>{dependency.kind}</Table.Cell>
+                    <Table.Cell>{dependency.name}</Table.Cell>
+                    <Table.Cell>{dependency.theorem}</Table.Cell>
+                  </Table.Row>
+                )
+              )}
+            </Table.Body>
+          </Table>
+        </>
+      )}
+
       <Header as="h4">ビルド出力</Header>
+      {judgeResult.log_key && (
+        <a
+          href={`${process.env.REACT_APP_API_ENDPOINT}/submissions/${
+            props.match.params.submissionId
+          }/log`}
+          target="_blank"
+          rel="noopener noreferrer"
+        >
+          全てのログ
+        </a>
+      )}
       <code>
         <pre>{judgeResult.message}</pre>
       </code>
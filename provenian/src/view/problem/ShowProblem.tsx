@@ -16,13 +16,42 @@ const getLanguageName = (language: string) => {
     return "Isabelle (2019)";
   }
 
+  const isabelle = language.match(/^isabelle(\d+)$/);
+  if (isabelle) {
+    return `Isabelle (${isabelle[1]})`;
+  }
+
+  if (language === "coq") {
+    return "Coq";
+  }
+
+  const coq = language.match(/^coq(.+)$/);
+  if (coq) {
+    return `Coq (${coq[1]})`;
+  }
+
+  if (language === "lean4") {
+    return "Lean 4";
+  }
+
+  const lean = language.match(/^lean4(.+)$/);
+  if (lean) {
+    return `Lean 4 (${lean[1]})`;
+  }
+
   throw new Error("unreachable");
 };
 
 const getLanguageColor = (language: string) => {
-  if (language === "isabelle") {
+  if (language.startsWith("isabelle")) {
     return "yellow";
   }
+  if (language.startsWith("coq")) {
+    return "grey";
+  }
+  if (language.startsWith("lean4")) {
+    return "blue";
+  }
 
   throw new Error("unreachable");
 };
@@ -46,6 +75,27 @@ const ShowProblem: React.FC<{
     { key: string; value: string; text: string }[]
   >([]);
 
+  // The template of the language, or the one without the version,
+  // or the one of the latest version
+  const getTemplate = (language: string) => {
+    const template = problem.template || {};
+    if (language in template) {
+      return template[language];
+    }
+
+    const base =
+      ["isabelle", "coq", "lean4"].find(base => language.startsWith(base)) ||
+      language;
+    if (base in template) {
+      return template[base];
+    }
+
+    const versions = Object.keys(template)
+      .filter(name => name.startsWith(base))
+      .sort();
+    return versions.length > 0 ? template[versions[versions.length - 1]] : "";
+  };
+
   useEffect(() => {
     setLanguageOptions(
       problem.languages.map(language => ({
@@ -113,6 +163,10 @@ const ShowProblem: React.FC<{
               placeholder="言語"
               options={languageOptions}
               onChange={(_, { value }) => {
+                // Prefill unless the code is written already
+                if (sourceCode === "" || sourceCode === getTemplate(language)) {
+                  setSourceCode(getTemplate(value as string));
+                }
                 setLanguage(value as string);
               }}
             />